@@ -3,6 +3,7 @@ package main
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -10,9 +11,16 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"lava-notes/internal/apierror"
+	"lava-notes/internal/archive"
 	"lava-notes/internal/auth"
+	"lava-notes/internal/backup"
 	"lava-notes/internal/cache"
 	"lava-notes/internal/db"
 	"lava-notes/internal/handlers"
@@ -24,7 +32,31 @@ func main() {
 	port := flag.Int("port", 2025, "Server port")
 	dataDir := flag.String("data", "./data", "Data directory")
 	generateLink := flag.Bool("generate-link", false, "Generate a new login link")
+	linkTTL := flag.Duration("ttl", 24*time.Hour, "Login link lifetime, used with --generate-link")
+	linkUses := flag.Int("uses", 1, "Number of logins allowed per link, used with --generate-link")
+	listLinks := flag.Bool("list-links", false, "List login links")
+	revokeLink := flag.Int64("revoke-link", 0, "Revoke the login link with given ID")
 	enableSSR := flag.Bool("ssr", false, "Enable SSR for SEO on note pages")
+	cacheEntries := flag.Int("cache-entries", envInt("CACHE_MAX_ENTRIES", cache.DefaultMaxEntries), "Maximum number of cached entries (env CACHE_MAX_ENTRIES)")
+	cacheMB := flag.Int("cache-mb", envInt("CACHE_MAX_MB", cache.DefaultMaxBytes>>20), "Maximum cache size in megabytes (env CACHE_MAX_MB)")
+	warmCache := flag.Int("warm-cache", envInt("CACHE_WARM", 0), "Preload the N most viewed public notes on startup (env CACHE_WARM)")
+	cacheTTL := flag.Duration("cache-ttl", envDuration("CACHE_TTL", cache.DefaultTTL), "Lifetime of cached entries (env CACHE_TTL)")
+	reset2FA := flag.Bool("reset-2fa", false, "Disable TOTP second factor and delete recovery codes")
+	exportPath := flag.String("export", "", "Export all notes to a Markdown zip archive and exit")
+	importPath := flag.String("import", "", "Import a folder or zip of Markdown notes (e.g. an Obsidian vault) and exit")
+	importStrategy := flag.String("import-strategy", "skip", "Handling of notes that already exist: skip, overwrite or rename, used with --import")
+	dryRun := flag.Bool("dry-run", false, "Only report what --import would do")
+	backupPath := flag.String("backup", "", "Write a consistent snapshot of the database to the given path and exit")
+	restorePath := flag.String("restore", "", "Replace the database with a snapshot after an integrity check and exit (stop the server first)")
+	backupInterval := flag.Duration("backup-interval", envDuration("BACKUP_INTERVAL", 0), "Take snapshots into <data>/backups at this interval, 0 disables (env BACKUP_INTERVAL)")
+	keepHourly := flag.Int("backup-keep-hourly", envInt("BACKUP_KEEP_HOURLY", backup.DefaultPolicy.Hourly), "Hourly snapshots to keep (env BACKUP_KEEP_HOURLY)")
+	keepDaily := flag.Int("backup-keep-daily", envInt("BACKUP_KEEP_DAILY", backup.DefaultPolicy.Daily), "Daily snapshots to keep (env BACKUP_KEEP_DAILY)")
+	listRemote := flag.Bool("list-remote", false, "List off-site snapshots (env BACKUP_S3_*) and exit")
+	restoreRemote := flag.String("restore-remote", "", "Download an off-site snapshot by name, or \"latest\", and restore it (stop the server first)")
+	keepWeekly := flag.Int("backup-keep-weekly", envInt("BACKUP_KEEP_WEEKLY", backup.DefaultPolicy.Weekly), "Weekly snapshots to keep (env BACKUP_KEEP_WEEKLY)")
+	checkDB := flag.Bool("check", false, "Check database integrity and consistency, print a JSON report and exit (non-zero on problems)")
+	vacuumDB := flag.Bool("vacuum", false, "Rebuild the database file to reclaim free space and exit")
+	optimizeDB := flag.Bool("optimize", false, "Refresh query planner statistics, truncate the WAL and exit")
 	flag.Parse()
 
 	if err := os.MkdirAll(*dataDir, 0755); err != nil {
@@ -32,12 +64,102 @@ func main() {
 	}
 
 	dbPath := filepath.Join(*dataDir, "lava.db")
+
+	// Off-site storage is configured with BACKUP_S3_ENDPOINT, BACKUP_S3_BUCKET,
+	// BACKUP_S3_REGION, BACKUP_S3_ACCESS_KEY, BACKUP_S3_SECRET_KEY, BACKUP_S3_PREFIX and BACKUP_PASSPHRASE
+	policy := backup.Policy{Hourly: *keepHourly, Daily: *keepDaily, Weekly: *keepWeekly}
+	var remote *backup.Remote
+	if cfg, ok := backup.RemoteConfigFromEnv(policy); ok {
+		r, err := backup.NewRemote(cfg)
+		if err != nil {
+			log.Fatalf("Invalid off-site backup configuration: %v", err)
+		}
+		remote = r
+	}
+
+	if *listRemote {
+		if remote == nil {
+			log.Fatal("Off-site backups are not configured, set BACKUP_S3_ENDPOINT")
+		}
+		snapshots, err := remote.List()
+		if err != nil {
+			log.Fatalf("Failed to list off-site snapshots: %v", err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tCREATED\tSIZE")
+		for _, s := range snapshots {
+			fmt.Fprintf(tw, "%s\t%s\t%d\n", s.Name, s.CreatedAt.Local().Format(time.DateTime), s.Size)
+		}
+		tw.Flush()
+		return
+	}
+
+	if *restoreRemote != "" {
+		if remote == nil {
+			log.Fatal("Off-site backups are not configured, set BACKUP_S3_ENDPOINT")
+		}
+		tmp := dbPath + ".download"
+		name, err := remote.Download(*restoreRemote, tmp)
+		if err != nil {
+			os.Remove(tmp)
+			log.Fatalf("Failed to download snapshot: %v", err)
+		}
+		*restorePath = tmp
+		defer os.Remove(tmp)
+		fmt.Printf("Downloaded %s\n", name)
+	}
+
+	// Restore before the database is opened, the file is swapped underneath it
+	if *restorePath != "" {
+		previous, err := backup.Restore(*restorePath, dbPath)
+		if err != nil {
+			log.Fatalf("Failed to restore: %v", err)
+		}
+		fmt.Printf("Restored %s from %s\n", dbPath, *restorePath)
+		if previous != "" {
+			fmt.Printf("The previous database was kept as %s\n", previous)
+		}
+		return
+	}
+
 	database, err := db.New(dbPath)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer database.Close()
 
+	if *checkDB {
+		report, err := database.Check()
+		if err != nil {
+			log.Fatalf("Failed to check database: %v", err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		if !report.OK {
+			database.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *vacuumDB || *optimizeDB {
+		if *vacuumDB {
+			before, after, err := database.Vacuum()
+			if err != nil {
+				log.Fatalf("Failed to vacuum database: %v", err)
+			}
+			fmt.Printf("Database vacuumed: %d -> %d bytes\n", before.SizeBytes, after.SizeBytes)
+		}
+		if *optimizeDB {
+			if err := database.Optimize(); err != nil {
+				log.Fatalf("Failed to optimize database: %v", err)
+			}
+			fmt.Println("Database optimized")
+		}
+		return
+	}
+
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
 		secretBytes := make([]byte, 32)
@@ -48,10 +170,149 @@ func main() {
 		log.Printf("Generated JWT secret (set JWT_SECRET env var to persist): %s", jwtSecret)
 	}
 
-	c := cache.New()
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("http://localhost:%d", *port)
+	}
+
 	a := auth.New(database, jwtSecret)
+
+	// One-shot commands exit before views, snapshots and limiters start their goroutines
+	if *reset2FA {
+		if err := a.ResetTOTP(); err != nil {
+			log.Fatalf("Failed to reset 2FA: %v", err)
+		}
+		fmt.Println("Two-factor authentication has been disabled")
+		return
+	}
+
+	if *backupPath != "" {
+		if err := backup.Write(database, *backupPath); err != nil {
+			log.Fatalf("Failed to back up database: %v", err)
+		}
+		fmt.Printf("Database backed up to %s\n", *backupPath)
+		return
+	}
+
+	if *exportPath != "" {
+		f, err := os.Create(*exportPath)
+		if err != nil {
+			log.Fatalf("Failed to create export file: %v", err)
+		}
+		manifest, err := archive.Export(database, f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(*exportPath)
+			log.Fatalf("Failed to export notes: %v", err)
+		}
+		notes, encrypted := 0, 0
+		for _, c := range manifest.Categories {
+			for _, n := range c.Notes {
+				notes++
+				if n.Encrypted {
+					encrypted++
+				}
+			}
+		}
+		fmt.Printf("Exported %d categories and %d notes (%d encrypted) to %s\n", len(manifest.Categories), notes, encrypted, *exportPath)
+		return
+	}
+
+	if *importPath != "" {
+		strategy, err := archive.ParseStrategy(*importStrategy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		src, closer, err := archive.OpenSource(*importPath)
+		if err != nil {
+			log.Fatalf("Failed to open %s: %v", *importPath, err)
+		}
+		defer closer.Close()
+		report, err := archive.Import(database, src, archive.ImportOptions{Strategy: strategy, DryRun: *dryRun})
+		if err != nil {
+			log.Fatalf("Failed to import notes: %v", err)
+		}
+		if report.Conflicts > 0 {
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "CONFLICT\tCATEGORY\tNOTE\tACTION")
+			for _, n := range report.Notes {
+				if n.Conflict {
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%s %s\n", n.Path, n.Category, n.Name, n.Action, n.FinalName)
+				}
+			}
+			tw.Flush()
+		}
+		for _, link := range report.LinksUnresolved {
+			fmt.Printf("Unresolved link: [[%s]]\n", link)
+		}
+		fmt.Println(report.Summary())
+		return
+	}
+
+	if *generateLink {
+		link, err := a.GenerateLoginLink(baseURL, *linkTTL, *linkUses)
+		if err != nil {
+			log.Fatalf("Failed to generate login link: %v", err)
+		}
+		fmt.Printf("\n=== Writer Login Link (uses: %d, valid for %s) ===\n%s\n\n", *linkUses, *linkTTL, link)
+		return
+	}
+
+	if *listLinks {
+		tokens, err := a.ListLoginTokens()
+		if err != nil {
+			log.Fatalf("Failed to list login links: %v", err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTOKEN\tCREATED\tEXPIRES\tUSES\tSTATUS")
+		for _, t := range tokens {
+			status := "active"
+			switch {
+			case t.Revoked:
+				status = "revoked"
+			case t.Uses >= t.MaxUses:
+				status = "used"
+			case time.Now().After(t.ExpiresAt):
+				status = "expired"
+			}
+			fmt.Fprintf(tw, "%d\t%s…\t%s\t%s\t%d/%d\t%s\n", t.ID, t.Token[:8],
+				t.CreatedAt.Local().Format(time.DateTime), t.ExpiresAt.Local().Format(time.DateTime), t.Uses, t.MaxUses, status)
+		}
+		tw.Flush()
+		return
+	}
+
+	if *revokeLink != 0 {
+		if err := a.RevokeLoginToken(*revokeLink); err != nil {
+			log.Fatalf("Failed to revoke login link %d: %v", *revokeLink, err)
+		}
+		fmt.Printf("Login link %d revoked\n", *revokeLink)
+		return
+	}
+
+	c := cache.New[string, any](cache.Options[any]{
+		MaxEntries: *cacheEntries,
+		MaxBytes:   int64(*cacheMB) << 20,
+		TTL:        *cacheTTL,
+		StaleTTL:   10 * time.Minute,
+	})
+	if err := a.ConfigureWebAuthn(baseURL); err != nil {
+		log.Printf("Passkeys disabled: %v", err)
+	}
+	// Origins allowed to make cookie-authenticated writes, comma-separated extras in TRUSTED_ORIGINS
+	trustedOrigins := []string{baseURL}
+	if extra := os.Getenv("TRUSTED_ORIGINS"); extra != "" {
+		trustedOrigins = append(trustedOrigins, strings.Split(extra, ",")...)
+	}
+	a.SetTrustedOrigins(trustedOrigins)
 	v := views.New(database)
-	h := handlers.New(database, c, a, v)
+	b := backup.New(database, filepath.Join(*dataDir, "backups"), policy, *backupInterval)
+	if remote != nil {
+		b.SetRemote(remote)
+	}
+	h := handlers.New(database, c, a, v, b)
 
 	// Graceful shutdown for views persistence
 	go func() {
@@ -59,107 +320,67 @@ func main() {
 		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 		<-sigChan
 		v.Shutdown()
+		// Closing checkpoints the WAL into lava.db
+		database.Close()
 		os.Exit(0)
 	}()
 
-	if *generateLink {
-		baseURL := os.Getenv("BASE_URL")
-		if baseURL == "" {
-			baseURL = fmt.Sprintf("http://localhost:%d", *port)
-		}
-		link, err := a.GenerateLoginLink(baseURL)
-		if err != nil {
-			log.Fatalf("Failed to generate login link: %v", err)
-		}
-		fmt.Printf("\n=== Writer Login Link (single use, valid for 24 hours) ===\n%s\n\n", link)
-		return
-	}
-
-	mux := http.NewServeMux()
-
-	// Static files
-	staticDir := "./static"
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(staticDir))))
-
-	// API routes
-	mux.HandleFunc("/api/categories", a.Middleware(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			h.GetCategories(w, r)
-		case http.MethodPost:
-			h.CreateCategory(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	}, false))
-
-	mux.HandleFunc("/api/categories/", a.Middleware(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			h.GetCategory(w, r)
-		case http.MethodPut:
-			h.UpdateCategory(w, r)
-		case http.MethodDelete:
-			h.DeleteCategory(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	}, false))
-
-	mux.HandleFunc("/api/notes", a.Middleware(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			h.GetNotes(w, r)
-		case http.MethodPost:
-			h.CreateNote(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	}, false))
-
-	mux.HandleFunc("/api/notes/search", a.Middleware(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			h.SearchNotes(w, r)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	}, false))
-
-	mux.HandleFunc("/api/notes/", a.Middleware(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			h.GetNote(w, r)
-		case http.MethodPut:
-			h.UpdateNote(w, r)
-		case http.MethodDelete:
-			h.DeleteNote(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	}, false))
-
-	mux.HandleFunc("/api/auth/check", a.Middleware(h.CheckAuth, false))
-	mux.HandleFunc("/api/auth/logout", h.Logout)
-	mux.HandleFunc("/auth/login", h.Login)
-
-	// Serve index.html for all other routes (SPA)
 	var ssrHandler *ssr.SSR
 	if *enableSSR {
-		ssrHandler = ssr.New(database, "./templates/index.html")
+		ssrHandler = ssr.New(database, c, v, "./templates/index.html")
+	}
+	mux := newMux(h, a, v.GetIPHeaderName(), ssrHandler)
+
+	if *warmCache > 0 {
+		go warm(database, h, ssrHandler, *warmCache)
 	}
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// Try SSR for note pages if enabled
-		if ssrHandler != nil && ssrHandler.ServeHTTP(w, r) {
-			return
-		}
-		http.ServeFile(w, r, "./templates/index.html")
-	})
 
 	addr := fmt.Sprintf(":%d", *port)
 	log.Printf("Starting Lava Notes server on %s", addr)
 	log.Printf("Run with --generate-link to create a writer login link")
 
-	if err := http.ListenAndServe(addr, mux); err != nil {
+	if err := http.ListenAndServe(addr, apierror.WithRequestID(mux)); err != nil {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
+
+// envInt returns an integer environment variable or def when unset or invalid
+func envInt(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return v
+	}
+	return def
+}
+
+// envDuration returns a duration environment variable (e.g. "30m") or def
+func envDuration(name string, def time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(name)); err == nil {
+		return v
+	}
+	return def
+}
+
+// warm preloads the most viewed public notes and their SSR pages in the
+// background. Notes are loaded one by one with a short pause so startup
+// traffic isn't competing with a burst of queries.
+func warm(database *db.DB, h *handlers.Handlers, ssrHandler *ssr.SSR, limit int) {
+	start := time.Now()
+	ids, err := database.GetTopViewedNoteIDs(limit)
+	if err != nil {
+		log.Printf("Cache warming failed: %v", err)
+		return
+	}
+
+	warmed := 0
+	for _, id := range ids {
+		if err := h.WarmNote(id); err != nil {
+			continue
+		}
+		if ssrHandler != nil {
+			ssrHandler.Warm(id)
+		}
+		warmed++
+		time.Sleep(10 * time.Millisecond)
+	}
+	log.Printf("Warmed cache with %d notes in %s", warmed, time.Since(start).Round(time.Millisecond))
+}
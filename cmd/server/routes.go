@@ -0,0 +1,289 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"lava-notes/internal/apierror"
+	"lava-notes/internal/auth"
+	"lava-notes/internal/handlers"
+	"lava-notes/internal/ratelimit"
+	"lava-notes/internal/ssr"
+)
+
+// newMux registers the routes described in internal/openapi/openapi.json, ssrHandler may be nil
+func newMux(h *handlers.Handlers, a *auth.Auth, ipHeader string, ssrHandler *ssr.SSR) *http.ServeMux {
+	// Abuse protection, clients are keyed by IP_HEADER or the remote address
+	loginLimiter := ratelimit.New(10, 5, ipHeader)
+	writeLimiter := ratelimit.New(120, 30, ipHeader)
+	searchLimiter := ratelimit.New(30, 10, ipHeader)
+	listLimiter := ratelimit.New(60, 20, ipHeader)
+	lockout := ratelimit.NewLockout(5, 15*time.Minute, ipHeader)
+	writeMethods := []string{http.MethodPost, http.MethodPut, http.MethodDelete}
+
+	mux := http.NewServeMux()
+
+	// Static files
+	staticDir := "./static"
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(staticDir))))
+
+	// API routes
+	mux.HandleFunc("/api/categories", writeLimiter.Middleware(a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			h.GetCategories(w, r)
+		case http.MethodPost:
+			h.CreateCategory(w, r)
+		default:
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, false), writeMethods...))
+
+	mux.HandleFunc("/api/categories/", writeLimiter.Middleware(a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			h.GetCategory(w, r)
+		case http.MethodPut:
+			h.UpdateCategory(w, r)
+		case http.MethodDelete:
+			h.DeleteCategory(w, r)
+		default:
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, false), writeMethods...))
+
+	mux.HandleFunc("/api/notes", writeLimiter.Middleware(a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			h.GetNotes(w, r)
+		case http.MethodPost:
+			h.CreateNote(w, r)
+		default:
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, false), writeMethods...))
+
+	mux.HandleFunc("/api/notes/recent", listLimiter.Middleware(a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			h.GetRecentNotes(w, r)
+		} else {
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, false)))
+
+	mux.HandleFunc("/api/notes/search", searchLimiter.Middleware(a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			h.SearchNotes(w, r)
+		} else {
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, false)))
+
+	mux.HandleFunc("/api/notes/", writeLimiter.Middleware(a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			h.GetNote(w, r)
+		case http.MethodPut:
+			h.UpdateNote(w, r)
+		case http.MethodDelete:
+			h.DeleteNote(w, r)
+		default:
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, false), writeMethods...))
+
+	mux.HandleFunc("/api/auth/check", a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			h.CheckAuth(w, r)
+		} else {
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, false))
+
+	mux.HandleFunc("/api/auth/logout", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			h.Logout(w, r)
+		} else {
+			apierror.MethodNotAllowed(w, r)
+		}
+	})
+
+	mux.HandleFunc("/api/auth/totp", loginLimiter.Middleware(a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			h.GetTOTP(w, r)
+		case http.MethodPost:
+			h.EnrollTOTP(w, r)
+		case http.MethodDelete:
+			h.DisableTOTP(w, r)
+		default:
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, true), http.MethodPost, http.MethodDelete))
+
+	mux.HandleFunc("/api/auth/totp/confirm", loginLimiter.Middleware(a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			h.ConfirmTOTP(w, r)
+		} else {
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, true)))
+
+	mux.HandleFunc("/auth/login", loginLimiter.Middleware(lockout.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodPost:
+			h.Login(w, r)
+		default:
+			apierror.MethodNotAllowed(w, r)
+		}
+	})))
+
+	mux.HandleFunc("/auth/passkey", a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			h.PasskeyPage(w, r)
+		} else {
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, false))
+
+	// WebAuthn passkeys
+	mux.HandleFunc("/api/auth/webauthn/register/begin", a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			h.WebAuthnRegisterBegin(w, r)
+		} else {
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, true))
+
+	mux.HandleFunc("/api/auth/webauthn/register/finish", a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			h.WebAuthnRegisterFinish(w, r)
+		} else {
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, true))
+
+	mux.HandleFunc("/api/auth/webauthn/login/begin", loginLimiter.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			h.WebAuthnLoginBegin(w, r)
+		} else {
+			apierror.MethodNotAllowed(w, r)
+		}
+	}))
+
+	mux.HandleFunc("/api/auth/webauthn/login/finish", loginLimiter.Middleware(lockout.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			h.WebAuthnLoginFinish(w, r)
+		} else {
+			apierror.MethodNotAllowed(w, r)
+		}
+	})))
+
+	mux.HandleFunc("/api/auth/webauthn/credentials", a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			h.GetPasskeys(w, r)
+		} else {
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, true))
+
+	mux.HandleFunc("/api/auth/webauthn/credentials/", a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			h.DeletePasskey(w, r)
+		} else {
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, true))
+
+	// Analytics routes
+	mux.HandleFunc("/api/analytics/notes/", a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			h.GetNoteAnalytics(w, r)
+		} else {
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, true))
+
+	mux.HandleFunc("/api/analytics/top", a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			h.GetTopAnalytics(w, r)
+		} else {
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, true))
+
+	mux.HandleFunc("/api/analytics/sources", a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			h.GetSourceAnalytics(w, r)
+		} else {
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, true))
+
+	// Export and import
+	mux.HandleFunc("/api/export", a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			h.Export(w, r)
+		} else {
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, true))
+
+	mux.HandleFunc("/api/import", a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			h.Import(w, r)
+		} else {
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, true))
+
+	// Admin routes
+	mux.HandleFunc("/api/admin/cache", a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			h.GetCacheStats(w, r)
+		case http.MethodDelete:
+			h.ClearCache(w, r)
+		default:
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, true))
+
+	mux.HandleFunc("/api/admin/backup", a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			h.ListBackups(w, r)
+		case http.MethodPost:
+			h.CreateBackup(w, r)
+		default:
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, true))
+
+	mux.HandleFunc("/api/admin/health/db", a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			h.GetDBHealth(w, r)
+		} else {
+			apierror.MethodNotAllowed(w, r)
+		}
+	}, true))
+
+	mux.HandleFunc("/api/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			h.OpenAPI(w, r)
+		} else {
+			apierror.MethodNotAllowed(w, r)
+		}
+	})
+
+	// Serve index.html for all other routes (SPA)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// Try SSR for note pages if enabled
+		if ssrHandler != nil && ssrHandler.ServeHTTP(w, r) {
+			return
+		}
+		http.ServeFile(w, r, "./templates/index.html")
+	})
+
+	return mux
+}
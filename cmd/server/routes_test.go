@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"lava-notes/internal/auth"
+	"lava-notes/internal/backup"
+	"lava-notes/internal/cache"
+	"lava-notes/internal/db"
+	"lava-notes/internal/handlers"
+	"lava-notes/internal/openapi"
+	"lava-notes/internal/views"
+	"lava-notes/pkg/client"
+)
+
+// spec is the part of openapi.json the contract tests read
+type spec struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Responses map[string]response `json:"responses"`
+		Schemas   map[string]schema   `json:"schemas"`
+	} `json:"components"`
+}
+
+type operation struct {
+	Responses map[string]response `json:"responses"`
+}
+
+type response struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema schema `json:"schema"`
+	} `json:"content"`
+}
+
+type schema struct {
+	Ref        string            `json:"$ref"`
+	Type       string            `json:"type"`
+	Items      *schema           `json:"items"`
+	Properties map[string]schema `json:"properties"`
+	AllOf      []schema          `json:"allOf"`
+}
+
+func loadSpec(t *testing.T) *spec {
+	t.Helper()
+	var s spec
+	if err := json.Unmarshal(openapi.Spec, &s); err != nil {
+		t.Fatal(err)
+	}
+	return &s
+}
+
+type testMux struct {
+	mux   *http.ServeMux
+	token string
+	ip    int
+}
+
+func newTestMux(t *testing.T) *testMux {
+	t.Helper()
+	dir := t.TempDir()
+	database, err := db.New(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	c := cache.New[string, any](cache.Options[any]{TTL: time.Hour})
+	a := auth.New(database, "test-secret")
+	token, err := a.GenerateJWT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := backup.New(database, filepath.Join(dir, "backups"), backup.Policy{}, 0)
+	h := handlers.New(database, c, a, views.New(database), b)
+	return &testMux{mux: newMux(h, a, "X-Real-IP", nil), token: token}
+}
+
+// do sends a request as the writer, every request from another client so no limiter kicks in
+func (m *testMux) do(method, path string, body interface{}) *httptest.ResponseRecorder {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Authorization", "Bearer "+m.token)
+	m.ip++
+	req.Header.Set("X-Real-IP", fmt.Sprintf("198.51.%d.%d", m.ip/256, m.ip%256))
+	rec := httptest.NewRecorder()
+	m.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+var pathParam = regexp.MustCompile(`\{[^}]+\}`)
+
+var methods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch}
+
+// Every path of the spec is routed and answers exactly the methods the spec lists
+func TestRoutesMatchSpec(t *testing.T) {
+	s := loadSpec(t)
+	m := newTestMux(t)
+
+	paths := make([]string, 0, len(s.Paths))
+	for p := range s.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		target := pathParam.ReplaceAllString(p, "1")
+		// Static files and the SPA fallback are served for any method
+		catchAll := p == "/{path}" || p == "/static/{path}"
+
+		_, pattern := m.mux.Handler(httptest.NewRequest(http.MethodGet, target, nil))
+		if (pattern == "/") != (p == "/{path}") {
+			t.Errorf("%s is routed to %q", p, pattern)
+			continue
+		}
+
+		for _, method := range methods {
+			_, declared := s.Paths[p][strings.ToLower(method)]
+			rec := m.do(method, target, nil)
+			switch {
+			case declared && rec.Code == http.StatusMethodNotAllowed:
+				t.Errorf("%s %s is in the spec but not allowed", method, p)
+			case !declared && !catchAll && rec.Code != http.StatusMethodNotAllowed:
+				t.Errorf("%s %s is not in the spec but answered %d", method, p, rec.Code)
+			case !declared && !catchAll && !strings.Contains(rec.Body.String(), `"code":"method_not_allowed"`):
+				t.Errorf("%s %s: 405 without the JSON error: %s", method, p, rec.Body.String())
+			}
+		}
+	}
+}
+
+// clientTypes are the pkg/client types of the spec's schemas
+var clientTypes = map[string]reflect.Type{
+	"Error":         reflect.TypeOf(client.Error{}),
+	"FieldError":    reflect.TypeOf(client.FieldError{}),
+	"Category":      reflect.TypeOf(client.Category{}),
+	"CategoryInput": reflect.TypeOf(client.CategoryInput{}),
+	"NoteWithViews": reflect.TypeOf(client.Note{}),
+	"NoteListItem":  reflect.TypeOf(client.NoteListItem{}),
+	"NoteInput":     reflect.TypeOf(client.NoteInput{}),
+	"SearchResult":  reflect.TypeOf(client.SearchResult{}),
+}
+
+// The client types have a field for every property of their schema and no others
+func TestClientTypesMatchSpec(t *testing.T) {
+	s := loadSpec(t)
+	for name, typ := range clientTypes {
+		want := s.properties(s.Components.Schemas[name])
+		if len(want) == 0 {
+			t.Errorf("schema %s has no properties", name)
+			continue
+		}
+		var got []string
+		for i := 0; i < typ.NumField(); i++ {
+			tag, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+			if tag != "" && tag != "-" {
+				got = append(got, tag)
+			}
+		}
+		sort.Strings(got)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("client.%s has fields %v, schema %s has %v", typ.Name(), got, name, want)
+		}
+	}
+}
+
+// properties lists the property names of a schema, following references and allOf
+func (s *spec) properties(sc schema) []string {
+	if sc.Ref != "" {
+		return s.properties(s.Components.Schemas[refName(sc.Ref)])
+	}
+	var names []string
+	for name := range sc.Properties {
+		names = append(names, name)
+	}
+	for _, part := range sc.AllOf {
+		names = append(names, s.properties(part)...)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func refName(ref string) string {
+	return ref[strings.LastIndexByte(ref, '/')+1:]
+}
+
+// Responses of the server decode into the client type of the schema the spec gives for them,
+// without unknown fields
+func TestSampleResponsesDecodeIntoClientTypes(t *testing.T) {
+	s := loadSpec(t)
+	m := newTestMux(t)
+
+	var category client.Category
+	rec := m.do(http.MethodPost, "/api/categories", client.CategoryInput{Name: "Contracts", Icon: "book"})
+	if err := json.Unmarshal(rec.Body.Bytes(), &category); err != nil || category.ID == 0 {
+		t.Fatalf("create category: %d %s", rec.Code, rec.Body.String())
+	}
+	var note client.Note
+	rec = m.do(http.MethodPost, "/api/notes", client.NoteInput{CategoryID: category.ID, Name: "Spec", Content: "contract testing"})
+	if err := json.Unmarshal(rec.Body.Bytes(), &note); err != nil || note.ID == 0 {
+		t.Fatalf("create note: %d %s", rec.Code, rec.Body.String())
+	}
+
+	samples := []struct {
+		method, path, target string
+		body                 interface{}
+		status               int
+	}{
+		{"GET", "/api/categories", "/api/categories", nil, 200},
+		{"POST", "/api/categories", "/api/categories", client.CategoryInput{Name: "Second"}, 201},
+		{"GET", "/api/categories/{id}", fmt.Sprintf("/api/categories/%d", category.ID), nil, 200},
+		{"PUT", "/api/categories/{id}", fmt.Sprintf("/api/categories/%d", category.ID), client.CategoryInput{Name: "Contracts"}, 200},
+		{"GET", "/api/categories/{id}", "/api/categories/999", nil, 404},
+		{"GET", "/api/notes", fmt.Sprintf("/api/notes?category_id=%d", category.ID), nil, 200},
+		{"GET", "/api/notes/recent", "/api/notes/recent", nil, 200},
+		{"GET", "/api/notes/search", "/api/notes/search?q=contract", nil, 200},
+		{"GET", "/api/notes/{id}", fmt.Sprintf("/api/notes/%d", note.ID), nil, 200},
+		{"PUT", "/api/notes/{id}", fmt.Sprintf("/api/notes/%d", note.ID), client.NoteInput{Name: "Spec", Content: "updated"}, 200},
+		{"GET", "/api/notes/{id}", "/api/notes/999", nil, 404},
+		{"POST", "/api/notes", "/api/notes", client.NoteInput{CategoryID: category.ID, Name: ""}, 400},
+	}
+	for _, sample := range samples {
+		name := fmt.Sprintf("%s %s %d", sample.method, sample.target, sample.status)
+		rec := m.do(sample.method, sample.target, sample.body)
+		if rec.Code != sample.status {
+			t.Errorf("%s: got %d: %s", name, rec.Code, rec.Body.String())
+			continue
+		}
+
+		typ, err := s.responseType(sample.path, sample.method, rec.Code)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		v := reflect.New(typ)
+		dec := json.NewDecoder(rec.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(v.Interface()); err != nil {
+			t.Errorf("%s: decoding into %s: %v", name, typ, err)
+			continue
+		}
+		if v.Elem().IsZero() {
+			t.Errorf("%s: decoded an empty %s", name, typ)
+		}
+	}
+}
+
+// responseType is the client type of a response in the spec, a slice for arrays
+func (s *spec) responseType(path, method string, status int) (reflect.Type, error) {
+	var op operation
+	if err := json.Unmarshal(s.Paths[path][strings.ToLower(method)], &op); err != nil {
+		return nil, err
+	}
+	resp, ok := op.Responses[fmt.Sprint(status)]
+	if !ok {
+		return nil, fmt.Errorf("status %d is not in the spec", status)
+	}
+	if resp.Ref != "" {
+		resp = s.Components.Responses[refName(resp.Ref)]
+	}
+	sc := resp.Content["application/json"].Schema
+	array := sc.Type == "array" && sc.Items != nil
+	if array {
+		sc = *sc.Items
+	}
+	typ, ok := clientTypes[refName(sc.Ref)]
+	if !ok {
+		return nil, fmt.Errorf("no client type for schema %q", sc.Ref)
+	}
+	if array {
+		return reflect.SliceOf(typ), nil
+	}
+	return typ, nil
+}
@@ -0,0 +1,134 @@
+// Package apierror writes JSON errors with stable codes API clients can branch on.
+// The "error" field keeps the human readable message, so older clients keep working.
+package apierror
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"regexp"
+)
+
+// Code identifies an error independently of its message, codes never change once published
+type Code string
+
+const (
+	CodeBadRequest         Code = "bad_request"
+	CodeValidationFailed   Code = "validation_failed"
+	CodeUnauthorized       Code = "unauthorized"
+	CodeInvalidToken       Code = "invalid_token"
+	CodeInvalidLoginLink   Code = "invalid_login_link"
+	CodeInvalidCode        Code = "invalid_code"
+	CodeCrossOrigin        Code = "cross_origin"
+	CodeNoteNotFound       Code = "note_not_found"
+	CodeCategoryNotFound   Code = "category_not_found"
+	CodeNotFound           Code = "not_found"
+	CodeNameConflict       Code = "name_conflict"
+	CodeConflict           Code = "conflict"
+	CodeMethodNotAllowed   Code = "method_not_allowed"
+	CodePayloadTooLarge    Code = "payload_too_large"
+	CodeRateLimited        Code = "rate_limited"
+	CodePasskeyFailed      Code = "passkey_failed"
+	CodeInvalidArchive     Code = "invalid_archive"
+	CodeInternal           Code = "internal_error"
+	CodeServiceUnavailable Code = "service_unavailable"
+)
+
+// FieldError describes why a single request field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type Error struct {
+	Status    int          `json:"-"`
+	Message   string       `json:"error"`
+	Code      Code         `json:"code"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+func New(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// Invalid reports a single rejected field
+func Invalid(field, message string) *Error {
+	return Validation(FieldError{Field: field, Message: message})
+}
+
+// Validation reports rejected fields, the message of the first one is used as the summary
+func Validation(fields ...FieldError) *Error {
+	message := "Validation failed"
+	if len(fields) > 0 {
+		message = fields[0].Message
+	}
+	return &Error{Status: http.StatusBadRequest, Code: CodeValidationFailed, Message: message, Fields: fields}
+}
+
+// Internal hides the cause of a server error behind a generic message
+func Internal(message string) *Error {
+	return New(http.StatusInternalServerError, CodeInternal, message)
+}
+
+// Common errors
+var (
+	ErrUnauthorized     = New(http.StatusUnauthorized, CodeUnauthorized, "Unauthorized")
+	ErrInvalidBody      = New(http.StatusBadRequest, CodeBadRequest, "Invalid request body")
+	ErrNoteNotFound     = New(http.StatusNotFound, CodeNoteNotFound, "Note not found")
+	ErrCategoryNotFound = New(http.StatusNotFound, CodeCategoryNotFound, "Category not found")
+	ErrMethodNotAllowed = New(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+	ErrRateLimited      = New(http.StatusTooManyRequests, CodeRateLimited, "Too many requests")
+)
+
+// Write sends err as JSON, tagged with the request ID
+func Write(w http.ResponseWriter, r *http.Request, err *Error) {
+	resp := *err
+	resp.RequestID = RequestID(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(err.Status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// MethodNotAllowed is the fallback branch of routes
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	Write(w, r, ErrMethodNotAllowed)
+}
+
+type requestIDKey struct{}
+
+// RequestIDHeader carries the request ID in both directions, so a proxy can supply its own
+const RequestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// WithRequestID assigns every request an ID, echoed in the response and in error bodies
+func WithRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestID returns the ID assigned by WithRequestID, empty outside of it
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,103 @@
+package archive
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// FormatVersion is bumped when the archive layout changes incompatibly
+const FormatVersion = 1
+
+// ManifestName is the file describing an archive, at its root
+const ManifestName = "manifest.json"
+
+// EncPrefix marks names and contents encrypted client-side (see static/main.js)
+const EncPrefix = "LAVA_ENC:"
+
+type Manifest struct {
+	Version    int                `json:"version"`
+	ExportedAt time.Time          `json:"exported_at"`
+	Categories []ManifestCategory `json:"categories"`
+}
+
+type ManifestCategory struct {
+	ID        int64          `json:"id"`
+	Name      string         `json:"name"`
+	Icon      string         `json:"icon"`
+	Path      string         `json:"path"`
+	Encrypted bool           `json:"encrypted,omitempty"`
+	Notes     []ManifestNote `json:"notes"`
+}
+
+type ManifestNote struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+}
+
+// invalidPathChars are replaced in file and folder names, they are reserved on common filesystems
+var invalidPathChars = regexp.MustCompile(`[/\\:*?"<>|\x00-\x1f]`)
+
+// tagPattern matches inline #tags, as used by Obsidian. Headings need a space after # and don't match.
+var tagPattern = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_][\p{L}\p{N}_/-]*)`)
+
+// IsEncrypted reports whether a name or content is ciphertext
+func IsEncrypted(s string) bool {
+	return strings.HasPrefix(s, EncPrefix)
+}
+
+// fileName turns a note or category name into a safe path element.
+// Encrypted names are replaced, the ciphertext is kept in front matter.
+func fileName(name string, id int64) string {
+	if IsEncrypted(name) {
+		return fmt.Sprintf("encrypted-%d", id)
+	}
+	name = strings.TrimSuffix(name, ".md")
+	name = invalidPathChars.ReplaceAllString(name, "_")
+	name = strings.Trim(name, ". ")
+	if name == "" {
+		return fmt.Sprintf("untitled-%d", id)
+	}
+	return name
+}
+
+// uniqueName returns name, or name with the id appended when it's already taken
+func uniqueName(name string, id int64, taken map[string]bool) string {
+	key := strings.ToLower(name)
+	if taken[key] {
+		name = fmt.Sprintf("%s (%d)", name, id)
+		key = strings.ToLower(name)
+	}
+	taken[key] = true
+	return name
+}
+
+// Tags returns the inline #tags of a note, skipping fenced code blocks
+func Tags(content string) []string {
+	if IsEncrypted(content) {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var tags []string
+	inCode := false
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			continue
+		}
+		for _, m := range tagPattern.FindAllStringSubmatch(line, -1) {
+			tag := strings.ToLower(m[1])
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
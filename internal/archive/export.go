@@ -0,0 +1,109 @@
+package archive
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"lava-notes/internal/db"
+	"lava-notes/internal/models"
+	"lava-notes/internal/views"
+)
+
+// Export writes every category as a folder and every note as a Markdown file
+// with front matter to a zip archive, along with a manifest. Encrypted notes
+// are exported as ciphertext and flagged, they can only be read with the key.
+func Export(database *db.DB, w io.Writer) (*Manifest, error) {
+	categories, err := database.GetCategories()
+	if err != nil {
+		return nil, err
+	}
+	notes, err := database.GetAllNotes()
+	if err != nil {
+		return nil, err
+	}
+	byCategory := make(map[int64][]models.Note)
+	for _, n := range notes {
+		byCategory[n.CategoryID] = append(byCategory[n.CategoryID], n)
+	}
+
+	zw := zip.NewWriter(w)
+	manifest := &Manifest{
+		Version:    FormatVersion,
+		ExportedAt: time.Now().UTC(),
+		Categories: make([]ManifestCategory, 0, len(categories)),
+	}
+
+	folders := make(map[string]bool)
+	for _, cat := range categories {
+		dir := uniqueName(fileName(cat.Name, cat.ID), cat.ID, folders)
+		mc := ManifestCategory{
+			ID:        cat.ID,
+			Name:      cat.Name,
+			Icon:      cat.Icon,
+			Path:      dir,
+			Encrypted: IsEncrypted(cat.Name),
+			Notes:     make([]ManifestNote, 0, len(byCategory[cat.ID])),
+		}
+
+		files := make(map[string]bool)
+		for _, n := range byCategory[cat.ID] {
+			encrypted := IsEncrypted(n.Name) || IsEncrypted(n.Content)
+			file := path.Join(dir, uniqueName(fileName(n.Name, n.ID), n.ID, files)+".md")
+			if err := writeNote(zw, file, cat, n, encrypted); err != nil {
+				return nil, err
+			}
+			mc.Notes = append(mc.Notes, ManifestNote{ID: n.ID, Name: n.Name, Path: file, Encrypted: encrypted})
+		}
+
+		// Keep empty categories as folders
+		if len(mc.Notes) == 0 {
+			if _, err := zw.Create(dir + "/"); err != nil {
+				return nil, err
+			}
+		}
+		manifest.Categories = append(manifest.Categories, mc)
+	}
+
+	mw, err := zw.Create(ManifestName)
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(mw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return nil, err
+	}
+
+	return manifest, zw.Close()
+}
+
+func writeNote(zw *zip.Writer, file string, cat models.Category, n models.Note, encrypted bool) error {
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: file, Method: zip.Deflate, Modified: n.UpdatedAt})
+	if err != nil {
+		return err
+	}
+
+	fm := FrontMatter{
+		ID:        n.ID,
+		Title:     n.Name,
+		Category:  cat.Name,
+		Icon:      n.Icon,
+		Tags:      Tags(n.Content),
+		Encrypted: encrypted,
+		Created:   n.CreatedAt,
+		Updated:   n.UpdatedAt,
+	}
+	if !IsEncrypted(n.Name) {
+		fm.Language = views.Language(n.Name)
+	}
+
+	var b strings.Builder
+	writeFrontMatter(&b, fm)
+	b.WriteString(n.Content)
+	_, err = io.WriteString(fw, b.String())
+	return err
+}
@@ -0,0 +1,162 @@
+package archive
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FrontMatter is the metadata block at the top of exported notes
+type FrontMatter struct {
+	ID        int64
+	Title     string
+	Category  string
+	Icon      string
+	Language  string
+	Tags      []string
+	Encrypted bool
+	Created   time.Time
+	Updated   time.Time
+}
+
+// writeFrontMatter renders front matter as YAML, strings are always quoted
+func writeFrontMatter(b *strings.Builder, fm FrontMatter) {
+	b.WriteString("---\n")
+	b.WriteString("id: " + strconv.FormatInt(fm.ID, 10) + "\n")
+	b.WriteString("title: " + strconv.Quote(fm.Title) + "\n")
+	b.WriteString("category: " + strconv.Quote(fm.Category) + "\n")
+	b.WriteString("icon: " + strconv.Quote(fm.Icon) + "\n")
+	if fm.Language != "" {
+		b.WriteString("language: " + strconv.Quote(fm.Language) + "\n")
+	}
+	if len(fm.Tags) > 0 {
+		quoted := make([]string, len(fm.Tags))
+		for i, t := range fm.Tags {
+			quoted[i] = strconv.Quote(t)
+		}
+		b.WriteString("tags: [" + strings.Join(quoted, ", ") + "]\n")
+	}
+	if fm.Encrypted {
+		b.WriteString("encrypted: true\n")
+	}
+	b.WriteString("created: " + fm.Created.UTC().Format(time.RFC3339) + "\n")
+	b.WriteString("updated: " + fm.Updated.UTC().Format(time.RFC3339) + "\n")
+	b.WriteString("---\n\n")
+}
+
+// parseFrontMatter splits a leading YAML block from a note. Only the subset
+// used by Lava exports and Obsidian is understood: scalars, quoted strings
+// and lists (inline or "- item"). Unknown keys are ignored.
+func parseFrontMatter(content string) (FrontMatter, string) {
+	var fm FrontMatter
+	content = strings.TrimPrefix(content, "\uFEFF")
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	if !strings.HasPrefix(content, "---\n") {
+		return fm, content
+	}
+	rest := content[len("---\n"):]
+	end := strings.Index(rest, "\n---")
+	if end < 0 {
+		return fm, content
+	}
+	block := rest[:end]
+	body := rest[end+len("\n---"):]
+	// The closing fence may be followed by the end of its line
+	if i := strings.IndexByte(body, '\n'); i >= 0 {
+		body = body[i+1:]
+	} else {
+		body = ""
+	}
+	body = strings.TrimLeft(body, "\n")
+
+	values := make(map[string]string)
+	lists := make(map[string][]string)
+	var listKey string
+	for _, line := range strings.Split(block, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if item, ok := strings.CutPrefix(trimmed, "- "); ok && listKey != "" {
+			lists[listKey] = append(lists[listKey], unquote(item))
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || strings.HasPrefix(line, " ") {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		listKey = ""
+		switch {
+		case value == "":
+			listKey = key
+		case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				if item = unquote(strings.TrimSpace(item)); item != "" {
+					lists[key] = append(lists[key], item)
+				}
+			}
+		default:
+			values[key] = unquote(value)
+		}
+	}
+
+	fm.ID, _ = strconv.ParseInt(values["id"], 10, 64)
+	fm.Title = values["title"]
+	fm.Category = values["category"]
+	fm.Icon = values["icon"]
+	fm.Language = values["language"]
+	fm.Encrypted = values["encrypted"] == "true"
+	fm.Tags = append(lists["tags"], splitTags(values["tags"])...)
+	fm.Created = parseTime(values, "created", "created_at", "date")
+	fm.Updated = parseTime(values, "updated", "updated_at", "modified")
+	return fm, body
+}
+
+// unquote strips YAML double or single quotes from a scalar
+func unquote(s string) string {
+	if len(s) >= 2 {
+		switch {
+		case s[0] == '"' && s[len(s)-1] == '"':
+			if u, err := strconv.Unquote(s); err == nil {
+				return u
+			}
+			return s[1 : len(s)-1]
+		case s[0] == '\'' && s[len(s)-1] == '\'':
+			return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+		}
+	}
+	// Trailing comments are allowed after unquoted scalars
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s
+}
+
+// splitTags reads tags written as a single scalar, e.g. "tags: go, web"
+func splitTags(s string) []string {
+	var tags []string
+	for _, t := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		tags = append(tags, strings.TrimPrefix(t, "#"))
+	}
+	return tags
+}
+
+var timeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02 15:04", time.DateOnly}
+
+// parseTime returns the first of keys holding a recognizable date
+func parseTime(values map[string]string, keys ...string) time.Time {
+	for _, key := range keys {
+		v := values[key]
+		if v == "" {
+			continue
+		}
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,111 @@
+package archive
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFrontMatter(t *testing.T) {
+	day := time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name, content string
+		want          FrontMatter
+		body          string
+	}{
+		{
+			name:    "no front matter",
+			content: "# Title\n\nText",
+			body:    "# Title\n\nText",
+		},
+		{
+			name:    "missing closing fence",
+			content: "---\ntitle: Draft\n\nText",
+			body:    "---\ntitle: Draft\n\nText",
+		},
+		{
+			name:    "lava export",
+			content: "---\nid: 12\ntitle: \"Quoted \\\"name\\\"\"\ncategory: \"Go\"\nicon: \"book\"\nlanguage: \"de\"\ntags: [\"go\", \"web\"]\nencrypted: true\ncreated: 2024-05-17T08:30:00Z\nupdated: 2024-05-18T09:00:00Z\n---\n\nBody\n",
+			want: FrontMatter{
+				ID: 12, Title: `Quoted "name"`, Category: "Go", Icon: "book", Language: "de",
+				Tags: []string{"go", "web"}, Encrypted: true,
+				Created: time.Date(2024, 5, 17, 8, 30, 0, 0, time.UTC),
+				Updated: time.Date(2024, 5, 18, 9, 0, 0, 0, time.UTC),
+			},
+			body: "Body\n",
+		},
+		{
+			name:    "single quotes and comments",
+			content: "---\n# a comment\ntitle: 'It''s here'\ncategory: Notes # inbox\n---\nBody",
+			want:    FrontMatter{Title: "It's here", Category: "Notes"},
+			body:    "Body",
+		},
+		{
+			name:    "dash list",
+			content: "---\ntags:\n  - go\n  - \"web\"\ntitle: After\n---\nBody",
+			want:    FrontMatter{Title: "After", Tags: []string{"go", "web"}},
+			body:    "Body",
+		},
+		{
+			name:    "scalar tags",
+			content: "---\ntags: #go, web\n---\n",
+			want:    FrontMatter{Tags: []string{"go", "web"}},
+		},
+		{
+			name:    "BOM and CRLF",
+			content: "\uFEFF---\r\ntitle: Windows\r\n---\r\n\r\nLine 1\r\nLine 2",
+			want:    FrontMatter{Title: "Windows"},
+			body:    "Line 1\nLine 2",
+		},
+		{
+			name:    "Obsidian dates",
+			content: "---\ndate: 2024-05-17\nmodified: 2024-05-17 14:05\n---\n",
+			want:    FrontMatter{Created: day, Updated: day.Add(14*time.Hour + 5*time.Minute)},
+		},
+		{
+			name:    "first recognizable date",
+			content: "---\ncreated: yesterday\ncreated_at: 2024-05-17 10:11:12\nupdated_at: 2024-05-17T10:11\n---\n",
+			want:    FrontMatter{Created: day.Add(10*time.Hour + 11*time.Minute + 12*time.Second), Updated: day.Add(10*time.Hour + 11*time.Minute)},
+		},
+		{
+			name:    "unknown keys and indented lines",
+			content: "---\naliases: [x]\nnested:\n  title: Inner\nid: nope\n---\nBody",
+			body:    "Body",
+		},
+	}
+	for _, tt := range tests {
+		fm, body := parseFrontMatter(tt.content)
+		if fmt.Sprint(fm) != fmt.Sprint(tt.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tt.name, fm, tt.want)
+		}
+		if body != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.name, body, tt.body)
+		}
+	}
+}
+
+// Front matter written by Export reads back unchanged
+func TestFrontMatterRoundTrip(t *testing.T) {
+	want := FrontMatter{
+		ID:       7,
+		Title:    `Notes: "quotes", #hash and 'apostrophes'`,
+		Category: "Go / Web",
+		Icon:     "devicon-go-plain",
+		Language: "de",
+		Tags:     []string{"go", "web/api"},
+		Created:  time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+		Updated:  time.Date(2024, 6, 7, 8, 9, 10, 0, time.UTC),
+	}
+	var b strings.Builder
+	writeFrontMatter(&b, want)
+	b.WriteString("---\nnot front matter\n")
+
+	got, body := parseFrontMatter(b.String())
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+	if body != "---\nnot front matter\n" {
+		t.Errorf("body %q", body)
+	}
+}
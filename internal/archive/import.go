@@ -0,0 +1,528 @@
+package archive
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"lava-notes/internal/db"
+	"lava-notes/internal/validate"
+)
+
+// Strategy decides what happens to a note whose name is already taken in its category
+type Strategy string
+
+const (
+	StrategySkip      Strategy = "skip"
+	StrategyOverwrite Strategy = "overwrite"
+	StrategyRename    Strategy = "rename"
+)
+
+// Actions reported per note
+const (
+	ActionCreate    = "create"
+	ActionOverwrite = "overwrite"
+	ActionRename    = "rename"
+	ActionSkip      = "skip"
+)
+
+// DefaultRootCategory receives notes at the root of a vault, outside any folder
+const DefaultRootCategory = "Imported"
+
+var ErrInvalidStrategy = errors.New("invalid strategy, expected skip, overwrite or rename")
+
+// wikiLinkPattern matches [[target]], [[target|alias]] and [[target#heading]]
+var wikiLinkPattern = regexp.MustCompile(`\[\[([^\]|#]+)(#[^\]|]*)?(\|[^\]]*)?\]\]`)
+
+// langSuffix matches the __xx language suffix kept at the end of renamed notes
+var langSuffix = regexp.MustCompile(`(?i)__[a-z]{2}$`)
+
+func ParseStrategy(s string) (Strategy, error) {
+	switch Strategy(s) {
+	case "":
+		return StrategySkip, nil
+	case StrategySkip, StrategyOverwrite, StrategyRename:
+		return Strategy(s), nil
+	}
+	return "", ErrInvalidStrategy
+}
+
+type ImportOptions struct {
+	Strategy     Strategy
+	DryRun       bool   // only report what would happen
+	RootCategory string // category for notes outside folders, DefaultRootCategory if empty
+}
+
+type ImportReport struct {
+	DryRun          bool             `json:"dry_run"`
+	Strategy        Strategy         `json:"strategy"`
+	Categories      []ImportCategory `json:"categories"`
+	Notes           []ImportNote     `json:"notes"`
+	Conflicts       int              `json:"conflicts"`
+	Created         int              `json:"created"`
+	Overwritten     int              `json:"overwritten"`
+	Renamed         int              `json:"renamed"`
+	Skipped         int              `json:"skipped"`
+	LinksRewritten  int              `json:"links_rewritten"`
+	LinksUnresolved []string         `json:"links_unresolved"`
+}
+
+type ImportCategory struct {
+	Name   string `json:"name"`
+	Icon   string `json:"icon"`
+	Exists bool   `json:"exists"`
+	ID     int64  `json:"id,omitempty"`
+}
+
+type ImportNote struct {
+	Path      string `json:"path"`
+	Category  string `json:"category"`
+	Name      string `json:"name"`
+	FinalName string `json:"final_name,omitempty"` // set when renamed
+	Action    string `json:"action"`
+	Conflict  bool   `json:"conflict"`
+	ID        int64  `json:"id,omitempty"`
+
+	fm      FrontMatter
+	content string
+}
+
+// Problem is an entry of an archive that can't be imported, Path is the category name for categories
+type Problem struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// InvalidError is returned when names, icons or contents break the rules of the API,
+// or when the manifest or an entry can't be read. Nothing is imported, every problem is listed.
+type InvalidError struct {
+	Problems []Problem
+}
+
+func (e *InvalidError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d invalid entries", len(e.Problems))
+	for _, p := range e.Problems {
+		fmt.Fprintf(&b, "\n  %s: %s", p.Path, p.Message)
+	}
+	return b.String()
+}
+
+// targetName is the name the note ends up with
+func (n *ImportNote) targetName() string {
+	if n.FinalName != "" {
+		return n.FinalName
+	}
+	return n.Name
+}
+
+// OpenSource opens a folder or a zip archive for Import. The returned closer must be called when done.
+func OpenSource(p string) (fs.FS, io.Closer, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.IsDir() {
+		return os.DirFS(p), noopCloser{}, nil
+	}
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	return zr, zr, nil
+}
+
+type noopCloser struct{}
+
+func (noopCloser) Close() error { return nil }
+
+// Import maps top-level folders of src to categories and Markdown files to
+// notes. Front matter restores titles, icons and timestamps, and wiki links
+// are rewritten to the imported names so they keep resolving.
+func Import(database *db.DB, src fs.FS, opts ImportOptions) (*ImportReport, error) {
+	if opts.Strategy == "" {
+		opts.Strategy = StrategySkip
+	}
+	if opts.RootCategory == "" {
+		opts.RootCategory = DefaultRootCategory
+	}
+
+	notes, categories, err := collect(src, opts.RootCategory)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{
+		DryRun:          opts.DryRun,
+		Strategy:        opts.Strategy,
+		Categories:      categories,
+		Notes:           notes,
+		LinksUnresolved: []string{},
+	}
+	if err := plan(database, report); err != nil {
+		return nil, err
+	}
+	rewriteLinks(report)
+	if err := check(report); err != nil {
+		return nil, err
+	}
+
+	if opts.DryRun {
+		return report, nil
+	}
+	return report, apply(database, report)
+}
+
+// collect reads all notes of src, sorted by path
+func collect(src fs.FS, rootCategory string) ([]ImportNote, []ImportCategory, error) {
+	// Archives written by Export describe their categories
+	folders := make(map[string]ManifestCategory)
+	if data, err := fs.ReadFile(src, ManifestName); err == nil {
+		var m Manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, nil, &InvalidError{Problems: []Problem{{Path: ManifestName, Message: "is not valid JSON"}}}
+		}
+		for _, c := range m.Categories {
+			folders[c.Path] = c
+		}
+	}
+
+	var notes []ImportNote
+	var categories []ImportCategory
+	seenCategories := make(map[string]bool)
+	addCategory := func(name, icon string) {
+		if !seenCategories[name] {
+			seenCategories[name] = true
+			categories = append(categories, ImportCategory{Name: name, Icon: icon})
+		}
+	}
+
+	err := fs.WalkDir(src, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Skip .obsidian, .trash and other hidden entries
+		if p != "." && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			// Empty top-level folders still become categories
+			if p != "." && !strings.Contains(p, "/") {
+				name, icon := p, "folder"
+				if c, ok := folders[p]; ok {
+					name, icon = c.Name, c.Icon
+				}
+				addCategory(name, icon)
+			}
+			return nil
+		}
+		ext := strings.ToLower(path.Ext(p))
+		if ext != ".md" && ext != ".markdown" {
+			return nil
+		}
+
+		data, err := fs.ReadFile(src, p)
+		if err != nil {
+			// A corrupt entry of an archive, e.g. a checksum mismatch
+			return &InvalidError{Problems: []Problem{{Path: p, Message: "can't be read"}}}
+		}
+		fm, body := parseFrontMatter(string(data))
+
+		folder, _, nested := strings.Cut(p, "/")
+		category, icon := rootCategory, "folder"
+		if nested {
+			category = folder
+			if c, ok := folders[folder]; ok {
+				category, icon = c.Name, c.Icon
+			}
+		}
+		if fm.Category != "" {
+			category = fm.Category
+		}
+		addCategory(category, icon)
+
+		name := fm.Title
+		if name == "" {
+			name = strings.TrimSuffix(path.Base(p), path.Ext(p))
+		}
+		notes = append(notes, ImportNote{Path: p, Category: category, Name: name, fm: fm, content: body})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.Slice(notes, func(i, j int) bool { return notes[i].Path < notes[j].Path })
+	return notes, categories, nil
+}
+
+// plan decides the action of every note against existing notes and earlier notes of the import
+func plan(database *db.DB, report *ImportReport) error {
+	taken := make(map[string]map[string]int64) // category -> note name -> existing note ID
+	for i := range report.Categories {
+		c := &report.Categories[i]
+		taken[c.Name] = make(map[string]int64)
+		existing, err := database.GetCategoryByName(c.Name)
+		if err != nil {
+			continue
+		}
+		c.Exists = true
+		c.ID = existing.ID
+		list, err := database.GetNotes(existing.ID)
+		if err != nil {
+			return err
+		}
+		for _, n := range list {
+			taken[c.Name][n.Name] = n.ID
+		}
+	}
+
+	imported := make(map[string]map[string]bool) // names claimed by this import
+	for i := range report.Notes {
+		n := &report.Notes[i]
+		if imported[n.Category] == nil {
+			imported[n.Category] = make(map[string]bool)
+		}
+		existingID, inDB := taken[n.Category][n.Name]
+		duplicate := imported[n.Category][n.Name]
+		n.Conflict = inDB || duplicate
+
+		switch {
+		case !n.Conflict:
+			n.Action = ActionCreate
+		case report.Strategy == StrategySkip:
+			n.Action = ActionSkip
+		case report.Strategy == StrategyOverwrite && !duplicate:
+			n.Action = ActionOverwrite
+			n.ID = existingID
+		default:
+			// Two files of the import with the same name can't overwrite each other, the later one is renamed
+			n.Action = ActionRename
+			n.FinalName = freeName(n.Name, func(name string) bool {
+				_, inDB := taken[n.Category][name]
+				return inDB || imported[n.Category][name]
+			})
+		}
+		if n.Action != ActionSkip {
+			imported[n.Category][n.targetName()] = true
+		}
+
+		if n.Conflict {
+			report.Conflicts++
+		}
+		switch n.Action {
+		case ActionCreate:
+			report.Created++
+		case ActionOverwrite:
+			report.Overwritten++
+		case ActionRename:
+			report.Renamed++
+		case ActionSkip:
+			report.Skipped++
+		}
+	}
+	return nil
+}
+
+// freeName appends " (2)", " (3)"... before the language suffix until the name is free
+func freeName(name string, taken func(string) bool) string {
+	base, ext := name, ""
+	if strings.HasSuffix(strings.ToLower(base), ".md") {
+		base, ext = base[:len(base)-3], base[len(base)-3:]
+	}
+	suffix := langSuffix.FindString(base)
+	base = strings.TrimSuffix(base, suffix)
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s%s", base, i, suffix, ext)
+		if !taken(candidate) {
+			return candidate
+		}
+	}
+}
+
+// rewriteLinks points [[wiki links]] at the final category and note names.
+// Targets are resolved like Obsidian does: by path within the vault, then by
+// file or note name, preferring notes in the same folder.
+func rewriteLinks(report *ImportReport) {
+	byPath := make(map[string]*ImportNote)
+	byName := make(map[string][]*ImportNote)
+	for i := range report.Notes {
+		n := &report.Notes[i]
+		p := strings.ToLower(strings.TrimSuffix(n.Path, path.Ext(n.Path)))
+		byPath[p] = n
+		byPath[strings.ToLower(n.Category+"/"+stripMd(n.Name))] = n
+		for _, key := range []string{strings.ToLower(path.Base(p)), strings.ToLower(stripMd(n.Name))} {
+			if len(byName[key]) == 0 || byName[key][len(byName[key])-1] != n {
+				byName[key] = append(byName[key], n)
+			}
+		}
+	}
+
+	unresolved := make(map[string]bool)
+	for i := range report.Notes {
+		n := &report.Notes[i]
+		if n.Action == ActionSkip || n.fm.Encrypted || IsEncrypted(n.content) {
+			continue
+		}
+		n.content = wikiLinkPattern.ReplaceAllStringFunc(n.content, func(link string) string {
+			m := wikiLinkPattern.FindStringSubmatch(link)
+			target := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(m[1]), ".md"))
+
+			to := byPath[target]
+			if to == nil {
+				candidates := byName[path.Base(target)]
+				for _, c := range candidates {
+					if c.Category == n.Category {
+						to = c
+						break
+					}
+				}
+				if to == nil && len(candidates) > 0 {
+					to = candidates[0]
+				}
+			}
+			if to == nil {
+				unresolved[m[1]] = true
+				return link
+			}
+
+			// Skipped notes keep the name of the note already in the database
+			name := stripMd(to.targetName())
+			rewritten := "[[" + name + "]]"
+			if to.Category != n.Category {
+				rewritten = "[[" + to.Category + "/" + name + "]]"
+			}
+			if rewritten != link {
+				report.LinksRewritten++
+			}
+			return rewritten
+		})
+	}
+	for link := range unresolved {
+		report.LinksUnresolved = append(report.LinksUnresolved, link)
+	}
+	sort.Strings(report.LinksUnresolved)
+}
+
+func stripMd(name string) string {
+	if strings.HasSuffix(strings.ToLower(name), ".md") {
+		return name[:len(name)-3]
+	}
+	return name
+}
+
+// check applies the validation rules of the API to new categories and imported notes
+func check(report *ImportReport) error {
+	var problems []Problem
+	add := func(p, subject string, err error) {
+		if err != nil {
+			problems = append(problems, Problem{Path: p, Message: subject + " " + err.Error()})
+		}
+	}
+	checkName := func(p, subject, name string, max int) {
+		if IsEncrypted(name) {
+			add(p, subject, validate.EncryptedName(name))
+		} else {
+			add(p, subject, validate.Name(name, max))
+		}
+	}
+
+	for _, c := range report.Categories {
+		if c.Exists {
+			continue
+		}
+		checkName(c.Name, "category name", c.Name, validate.MaxCategoryNameLength)
+		add(c.Name, "category icon", validate.Icon(c.Icon))
+	}
+	for _, n := range report.Notes {
+		if n.Action == ActionSkip {
+			continue
+		}
+		checkName(n.Path, "name", n.targetName(), validate.MaxNameLength)
+		add(n.Path, "icon", validate.Icon(n.fm.Icon))
+		add(n.Path, "content", validate.Content(n.content))
+	}
+
+	if len(problems) > 0 {
+		return &InvalidError{Problems: problems}
+	}
+	return nil
+}
+
+// apply writes the planned categories and notes in one transaction, a failure imports nothing
+func apply(database *db.DB, report *ImportReport) error {
+	return database.Transaction(func(tx *db.Tx) error {
+		categoryIDs := make(map[string]int64)
+		for i := range report.Categories {
+			c := &report.Categories[i]
+			if !c.Exists {
+				id, err := tx.CreateCategory(c.Name, c.Icon)
+				if err != nil {
+					return fmt.Errorf("failed to create category %q: %w", c.Name, err)
+				}
+				c.ID = id
+			}
+			categoryIDs[c.Name] = c.ID
+		}
+
+		for i := range report.Notes {
+			n := &report.Notes[i]
+			if n.Action == ActionSkip {
+				continue
+			}
+			categoryID := categoryIDs[n.Category]
+			icon := n.fm.Icon
+			if icon == "" {
+				icon = "file-text"
+			}
+			// Notes of locked categories are hidden by their icon, like in CreateNote
+			private, err := tx.IsCategoryPrivate(categoryID)
+			if err != nil {
+				return fmt.Errorf("failed to look up category %q: %w", n.Category, err)
+			}
+			if private {
+				icon = "lock"
+			}
+
+			if n.Action == ActionOverwrite {
+				if err := tx.UpdateNote(n.ID, categoryID, n.Name, n.content, icon); err != nil {
+					return fmt.Errorf("failed to overwrite %s: %w", n.Path, err)
+				}
+			} else {
+				id, err := tx.CreateNote(categoryID, n.targetName(), n.content, icon)
+				if err != nil {
+					return fmt.Errorf("failed to import %s: %w", n.Path, err)
+				}
+				n.ID = id
+			}
+
+			if !n.fm.Created.IsZero() || !n.fm.Updated.IsZero() {
+				if err := tx.SetNoteTimestamps(n.ID, n.fm.Created, n.fm.Updated); err != nil {
+					return fmt.Errorf("failed to set timestamps of %s: %w", n.Path, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Summary formats the counts of a report for the CLI
+func (r *ImportReport) Summary() string {
+	verb := "Imported"
+	if r.DryRun {
+		verb = "Would import"
+	}
+	return fmt.Sprintf("%s %d notes in %d categories: %d created, %d overwritten, %d renamed, %d skipped (%d conflicts, %d links rewritten, %d unresolved)",
+		verb, len(r.Notes), len(r.Categories), r.Created, r.Overwritten, r.Renamed, r.Skipped, r.Conflicts, r.LinksRewritten, len(r.LinksUnresolved))
+}
@@ -0,0 +1,344 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"lava-notes/internal/db"
+)
+
+func newTestDB(t *testing.T) *db.DB {
+	t.Helper()
+	d, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func file(content string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(content)}
+}
+
+// noteContent returns the content of a note, failing when it doesn't exist
+func noteContent(t *testing.T, d *db.DB, category, name string) string {
+	t.Helper()
+	c, err := d.GetCategoryByName(category)
+	if err != nil {
+		t.Fatalf("category %q: %v", category, err)
+	}
+	n, err := d.GetNoteByName(c.ID, name)
+	if err != nil {
+		t.Fatalf("note %q in %q: %v", name, category, err)
+	}
+	return n.Content
+}
+
+func TestFreeName(t *testing.T) {
+	tests := []struct {
+		name  string
+		taken []string
+		want  string
+	}{
+		{"Intro", nil, "Intro (2)"},
+		{"Intro", []string{"Intro (2)", "Intro (3)"}, "Intro (4)"},
+		{"Intro__de", []string{"Intro (2)__de"}, "Intro (3)__de"},
+		{"Intro__DE", nil, "Intro (2)__DE"},
+		{"Intro.md", []string{"Intro (2).md"}, "Intro (3).md"},
+		{"Intro__fr.md", nil, "Intro (2)__fr.md"},
+		{"snake__case__x", nil, "snake__case__x (2)"},
+		{"Intro (2)", nil, "Intro (2) (2)"},
+	}
+	for _, tt := range tests {
+		taken := make(map[string]bool)
+		for _, n := range tt.taken {
+			taken[n] = true
+		}
+		if got := freeName(tt.name, func(n string) bool { return taken[n] }); got != tt.want {
+			t.Errorf("freeName(%q) with %v taken: got %q, want %q", tt.name, tt.taken, got, tt.want)
+		}
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	for s, want := range map[string]Strategy{"": StrategySkip, "skip": StrategySkip, "overwrite": StrategyOverwrite, "rename": StrategyRename} {
+		if got, err := ParseStrategy(s); err != nil || got != want {
+			t.Errorf("ParseStrategy(%q): got %q, %v", s, got, err)
+		}
+	}
+	if _, err := ParseStrategy("merge"); !errors.Is(err, ErrInvalidStrategy) {
+		t.Errorf("ParseStrategy(merge): got %v, want ErrInvalidStrategy", err)
+	}
+}
+
+func TestImportStrategies(t *testing.T) {
+	src := fstest.MapFS{
+		"Go/Existing.md":  file("new content"),
+		"Go/Fresh.md":     file("fresh"),
+		"Go/Twin.md":      file("---\ntitle: Fresh\n---\nsecond fresh"), // the same name as Fresh.md
+		"Go/.obsidian/x":  file("ignored"),
+		"Go/image.png":    file("ignored"),
+		"Loose.markdown":  file("at the root"),
+		".trash/Gone.md":  file("ignored"),
+		"Empty/.keep.md":  file("ignored"),
+		"Go/Sub/Deep.md":  file("nested folders belong to the top-level category"),
+		"Go/Intro__de.md": file("hallo"),
+	}
+	tests := []struct {
+		strategy                               Strategy
+		created, overwritten, renamed, skipped int
+		names                                  map[string]string // note name in Go -> content
+	}{
+		{StrategySkip, 3, 0, 0, 3, map[string]string{
+			"Existing": "old content", "Fresh": "fresh", "Intro__de": "alt", "Deep": "nested folders belong to the top-level category",
+		}},
+		{StrategyOverwrite, 3, 2, 1, 0, map[string]string{
+			"Existing": "new content", "Fresh": "fresh", "Fresh (2)": "second fresh", "Intro__de": "hallo",
+		}},
+		{StrategyRename, 3, 0, 3, 0, map[string]string{
+			"Existing": "old content", "Existing (2)": "new content", "Fresh (2)": "second fresh", "Intro (2)__de": "hallo",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			d := newTestDB(t)
+			category, err := d.CreateCategory("Go", "devicon-go-plain")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range map[string]string{"Existing": "old content", "Intro__de": "alt"} {
+				if _, err := d.CreateNote(category.ID, name, content, ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			report, err := Import(d, src, ImportOptions{Strategy: tt.strategy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Created != tt.created || report.Overwritten != tt.overwritten || report.Renamed != tt.renamed || report.Skipped != tt.skipped {
+				t.Errorf("got %s", report.Summary())
+			}
+			if report.Conflicts != 3 {
+				t.Errorf("got %d conflicts, want 3", report.Conflicts)
+			}
+			if len(report.Notes) != 6 {
+				t.Errorf("got %d notes, want 6: %+v", len(report.Notes), report.Notes)
+			}
+			for name, want := range tt.names {
+				if got := noteContent(t, d, "Go", name); got != want {
+					t.Errorf("%s: got %q, want %q", name, got, want)
+				}
+			}
+			if got := noteContent(t, d, DefaultRootCategory, "Loose"); got != "at the root" {
+				t.Errorf("root note: got %q", got)
+			}
+			if _, err := d.GetCategoryByName("Empty"); err != nil {
+				t.Errorf("empty folder: %v", err)
+			}
+			for _, hidden := range []string{".trash", ".obsidian"} {
+				if _, err := d.GetCategoryByName(hidden); err == nil {
+					t.Errorf("hidden folder %s was imported", hidden)
+				}
+			}
+		})
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	d := newTestDB(t)
+	src := fstest.MapFS{"Go/Note.md": file("text"), "Other/Note.md": file("text")}
+	report, err := Import(d, src, ImportOptions{DryRun: true, RootCategory: "Inbox"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun || report.Created != 2 || len(report.Categories) != 2 {
+		t.Fatalf("got %s", report.Summary())
+	}
+	categories, err := d.GetCategories()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(categories) != 0 {
+		t.Fatalf("dry run created %d categories", len(categories))
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      fstest.MapFS
+		problems []string // paths of the problems
+	}{
+		{"manifest", fstest.MapFS{ManifestName: file("{"), "Go/Note.md": file("")}, []string{ManifestName}},
+		{"names and icons", fstest.MapFS{
+			"Go/Ok.md":   file(""),
+			"Go/Icon.md": file("---\nicon: \"not an icon!\"\n---\n"),
+			"Go/Long.md": file("---\ntitle: " + strings.Repeat("x", 1000) + "\n---\n"),
+			"Bad/N.md":   file("---\ncategory: " + strings.Repeat("c", 101) + "\n---\n"),
+		}, []string{strings.Repeat("c", 101), "Go/Icon.md", "Go/Long.md"}},
+	}
+	for _, tt := range tests {
+		d := newTestDB(t)
+		_, err := Import(d, tt.src, ImportOptions{})
+		var invalid *InvalidError
+		if !errors.As(err, &invalid) {
+			t.Errorf("%s: got %v, want an InvalidError", tt.name, err)
+			continue
+		}
+		var paths []string
+		for _, p := range invalid.Problems {
+			paths = append(paths, p.Path)
+		}
+		if fmt.Sprint(paths) != fmt.Sprint(tt.problems) {
+			t.Errorf("%s: problems %v, want %v", tt.name, invalid.Problems, tt.problems)
+		}
+		if categories, _ := d.GetCategories(); len(categories) != 0 {
+			t.Errorf("%s: an invalid import created %d categories", tt.name, len(categories))
+		}
+	}
+}
+
+func TestImportRewritesLinks(t *testing.T) {
+	d := newTestDB(t)
+	category, err := d.CreateCategory("Go", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.CreateNote(category.ID, "Setup", "taken", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	src := fstest.MapFS{
+		"Go/Setup.md":     file("renamed on import"),
+		"Go/Index.md":     file("[[Setup]] [[setup.md|the setup]] [[Go/Setup#Install]] [[Web/Index]] [[Routing]] [[Missing]]"),
+		"Web/Index.md":    file("[[Index]] [[Go/Index|back]] [[Setup]] [[Nowhere|x]]"),
+		"Web/Router.md":   file("---\ntitle: Routing\n---\n[[Router]]"),
+		"Web/Secret.md":   file("---\nencrypted: true\n---\n[[Index]]"),
+		"Web/Ciphered.md": file(EncPrefix + "[[Index]]"),
+	}
+	report, err := Import(d, src, ImportOptions{Strategy: StrategyRename})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"Go/Index":     "[[Setup (2)]] [[Setup (2)]] [[Setup (2)]] [[Web/Index]] [[Web/Routing]] [[Missing]]",
+		"Web/Index":    "[[Index]] [[Go/Index]] [[Go/Setup (2)]] [[Nowhere|x]]",
+		"Web/Routing":  "[[Routing]]",
+		"Web/Secret":   "[[Index]]",
+		"Web/Ciphered": EncPrefix + "[[Index]]",
+	}
+	for key, content := range want {
+		category, name, _ := strings.Cut(key, "/")
+		if got := noteContent(t, d, category, name); got != content {
+			t.Errorf("%s:\ngot  %q\nwant %q", key, got, content)
+		}
+	}
+	if report.LinksRewritten != 7 {
+		t.Errorf("got %d links rewritten, want 7", report.LinksRewritten)
+	}
+	if fmt.Sprint(report.LinksUnresolved) != "[Missing Nowhere]" {
+		t.Errorf("got unresolved links %v", report.LinksUnresolved)
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	from := newTestDB(t)
+	created := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	updated := created.Add(48 * time.Hour)
+
+	type note struct{ category, name, content, icon string }
+	notes := []note{
+		{"Go", "Channels", "# Channels\n\n#concurrency [[Goroutines__de]]\n", "devicon-go-plain"},
+		{"Go", "Goroutines__de", "---\nnot front matter\n---\n", "file-text"},
+		{"Go", "a: b?", "reserved characters in the name", "file-text"},
+		{"Go", "A: B?", "the same file name, different case", "book"},
+		{"Private", "Diary", "locked", "lock"},
+		{"Private", EncPrefix + "bmFtZQ", EncPrefix + "Y29udGVudA", "lock"},
+	}
+	icons := map[string]string{"Go": "devicon-go-plain", "Private": "lock", "Empty": "folder", "Go?": "book", "Go*": "book"} // the last two share a folder name
+	for name, icon := range icons {
+		if _, err := from.CreateCategory(name, icon); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range notes {
+		c, err := from.GetCategoryByName(n.category)
+		if err != nil {
+			t.Fatal(err)
+		}
+		added, err := from.CreateNote(c.ID, n.name, n.content, n.icon)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := from.SetNoteTimestamps(added.ID, created, updated); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	manifest, err := Export(from, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Categories) != len(icons) {
+		t.Fatalf("manifest has %d categories, want %d", len(manifest.Categories), len(icons))
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	to := newTestDB(t)
+	report, err := Import(to, zr, ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != len(notes) || report.Conflicts != 0 || report.LinksRewritten != 0 || len(report.LinksUnresolved) != 0 {
+		t.Fatalf("got %s", report.Summary())
+	}
+
+	for name, icon := range icons {
+		c, err := to.GetCategoryByName(name)
+		if err != nil {
+			t.Fatalf("category %q: %v", name, err)
+		}
+		if c.Icon != icon {
+			t.Errorf("category %q: icon %q, want %q", name, c.Icon, icon)
+		}
+	}
+	for _, want := range notes {
+		c, err := to.GetCategoryByName(want.category)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := to.GetNoteByName(c.ID, want.name)
+		if err != nil {
+			t.Errorf("note %q in %q: %v", want.name, want.category, err)
+			continue
+		}
+		if got.Content != want.content || got.Icon != want.icon {
+			t.Errorf("note %q: got %q with icon %q, want %q with icon %q", want.name, got.Content, got.Icon, want.content, want.icon)
+		}
+		if !got.CreatedAt.Equal(created) || !got.UpdatedAt.Equal(updated) {
+			t.Errorf("note %q: timestamps %s, %s, want %s, %s", want.name, got.CreatedAt, got.UpdatedAt, created, updated)
+		}
+	}
+	// A second import of the same archive only finds conflicts
+	report, err = Import(to, zr, ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Skipped != len(notes) || report.Created != 0 {
+		t.Errorf("second import: %s", report.Summary())
+	}
+}
@@ -10,7 +10,9 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"lava-notes/internal/apierror"
 	"lava-notes/internal/db"
+	"lava-notes/internal/models"
 )
 
 type contextKey string
@@ -20,10 +22,17 @@ const userRoleKey contextKey = "userRole"
 var ErrInvalidToken = errors.New("invalid token")
 var ErrTokenExpired = errors.New("token expired")
 var ErrTokenUsed = errors.New("token already used")
+var ErrTokenRevoked = errors.New("token revoked")
+
+// Used and expired login tokens are kept this long before purging, so a
+// late click still gets a meaningful error instead of "invalid token"
+const tokenPurgeGrace = 24 * time.Hour
 
 type Auth struct {
-	db        *db.DB
-	jwtSecret []byte
+	db             *db.DB
+	jwtSecret      []byte
+	webauthn       webAuthnState
+	trustedOrigins map[string]bool
 }
 
 type Claims struct {
@@ -32,45 +41,125 @@ type Claims struct {
 }
 
 func New(database *db.DB, secret string) *Auth {
-	return &Auth{
+	a := &Auth{
 		db:        database,
 		jwtSecret: []byte(secret),
+		webauthn:  webAuthnState{challenges: make(map[string]challenge)},
 	}
+
+	// Start login token cleanup goroutine
+	go a.purgeLoop()
+
+	return a
 }
 
-func (a *Auth) GenerateLoginLink(baseURL string) (string, error) {
+// GenerateLoginLink creates a link valid for ttl that can be used maxUses times
+func (a *Auth) GenerateLoginLink(baseURL string, ttl time.Duration, maxUses int) (string, error) {
+	if maxUses < 1 {
+		maxUses = 1
+	}
 	token := make([]byte, 32)
 	if _, err := rand.Read(token); err != nil {
 		return "", err
 	}
 	tokenStr := hex.EncodeToString(token)
 
-	expiresAt := time.Now().Add(24 * time.Hour) // Link valid for 24 hours
-	if err := a.db.CreateAuthToken(tokenStr, expiresAt); err != nil {
+	expiresAt := time.Now().Add(ttl)
+	if err := a.db.CreateAuthToken(tokenStr, expiresAt, maxUses); err != nil {
 		return "", err
 	}
 
 	return baseURL + "/auth/login?token=" + tokenStr, nil
 }
 
-func (a *Auth) ValidateLoginToken(token string) (string, error) {
+// ListLoginTokens returns all stored login tokens, oldest first
+func (a *Auth) ListLoginTokens() ([]models.AuthToken, error) {
+	return a.db.GetAuthTokens()
+}
+
+// RevokeLoginToken cancels a login token before it expires
+func (a *Auth) RevokeLoginToken(id int64) error {
+	ok, err := a.db.RevokeAuthToken(id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// PurgeLoginTokens deletes tokens that are revoked, used up or expired
+// for longer than the grace period, returns the number deleted
+func (a *Auth) PurgeLoginTokens() (int, error) {
+	tokens, err := a.db.GetAuthTokens()
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-tokenPurgeGrace)
+	var stale []int64
+	for _, t := range tokens {
+		if t.Revoked || t.ExpiresAt.Before(cutoff) || (t.Uses >= t.MaxUses && t.CreatedAt.Before(cutoff)) {
+			stale = append(stale, t.ID)
+		}
+	}
+	if len(stale) == 0 {
+		return 0, nil
+	}
+	return len(stale), a.db.DeleteAuthTokens(stale)
+}
+
+func (a *Auth) purgeLoop() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		a.PurgeLoginTokens()
+	}
+}
+
+// CheckLoginToken verifies a login token without consuming it
+func (a *Auth) CheckLoginToken(token string) error {
 	authToken, err := a.db.GetAuthToken(token)
 	if err != nil {
-		return "", ErrInvalidToken
+		return ErrInvalidToken
 	}
 
-	if authToken.Used {
-		return "", ErrTokenUsed
+	if authToken.Revoked {
+		return ErrTokenRevoked
+	}
+
+	if authToken.Used || authToken.Uses >= authToken.MaxUses {
+		return ErrTokenUsed
 	}
 
 	if time.Now().After(authToken.ExpiresAt) {
-		return "", ErrTokenExpired
+		return ErrTokenExpired
+	}
+	return nil
+}
+
+// ValidateLoginToken consumes a login token and issues a JWT.
+// code is checked against TOTP or recovery codes when 2FA is enabled.
+func (a *Auth) ValidateLoginToken(token, code string) (string, error) {
+	if err := a.CheckLoginToken(token); err != nil {
+		return "", err
 	}
 
-	if err := a.db.MarkTokenUsed(token); err != nil {
+	if err := a.VerifySecondFactor(code); err != nil {
 		return "", err
 	}
 
+	// Checked again atomically, so concurrent clicks can't both succeed
+	consumed, err := a.db.ConsumeAuthToken(token)
+	if err != nil {
+		return "", err
+	}
+	if !consumed {
+		return "", ErrTokenUsed
+	}
+
 	return a.GenerateJWT()
 }
 
@@ -112,16 +201,25 @@ func (a *Auth) Middleware(next http.HandlerFunc, requireAuth bool) http.HandlerF
 	return func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 
+		fromCookie := false
 		if authHeader == "" {
 			cookie, err := r.Cookie("lava_token")
 			if err == nil {
 				authHeader = "Bearer " + cookie.Value
+				fromCookie = true
 			}
 		}
 
+		// Cookies are sent automatically by browsers, so writes authenticated
+		// by them must come from a trusted origin (CSRF protection)
+		if fromCookie && !isSafeMethod(r.Method) && !a.sameOrigin(r) {
+			apierror.Write(w, r, apierror.New(http.StatusForbidden, apierror.CodeCrossOrigin, "Cross-origin request rejected"))
+			return
+		}
+
 		if authHeader == "" {
 			if requireAuth {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				apierror.Write(w, r, apierror.ErrUnauthorized)
 				return
 			}
 			next(w, r)
@@ -131,7 +229,7 @@ func (a *Auth) Middleware(next http.HandlerFunc, requireAuth bool) http.HandlerF
 		parts := strings.SplitN(authHeader, " ", 2)
 		if len(parts) != 2 || parts[0] != "Bearer" {
 			if requireAuth {
-				http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
+				apierror.Write(w, r, apierror.New(http.StatusUnauthorized, apierror.CodeInvalidToken, "Invalid authorization header"))
 				return
 			}
 			next(w, r)
@@ -141,7 +239,7 @@ func (a *Auth) Middleware(next http.HandlerFunc, requireAuth bool) http.HandlerF
 		claims, err := a.ValidateJWT(parts[1])
 		if err != nil {
 			if requireAuth {
-				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				apierror.Write(w, r, apierror.New(http.StatusUnauthorized, apierror.CodeInvalidToken, "Invalid token"))
 				return
 			}
 			next(w, r)
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SetTrustedOrigins sets the origins allowed to make cookie-authenticated writes.
+// Entries may be full URLs, only scheme://host[:port] is compared.
+func (a *Auth) SetTrustedOrigins(origins []string) {
+	trusted := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		if origin := normalizeOrigin(strings.TrimSpace(o)); origin != "" {
+			trusted[origin] = true
+		}
+	}
+	a.trustedOrigins = trusted
+}
+
+func normalizeOrigin(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// sameOrigin verifies that a state-changing request comes from a trusted origin.
+// Browsers always send Origin on cross-origin and same-origin non-GET fetches,
+// Referer is used as a fallback for older clients that omit it.
+func (a *Auth) sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		origin = r.Header.Get("Referer")
+	}
+	origin = normalizeOrigin(origin)
+	if origin == "" {
+		return false
+	}
+	if a.trustedOrigins[origin] {
+		return true
+	}
+
+	// Direct access without a proxy rewriting Host, e.g. http://192.168.1.5:2025
+	host := strings.ToLower(r.Host)
+	return origin == "http://"+host || origin == "https://"+host
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSameOrigin(t *testing.T) {
+	a := newTestAuth(t)
+	a.SetTrustedOrigins([]string{"https://Notes.Example.com/app/", " http://localhost:3000 ", "not a url"})
+
+	tests := []struct {
+		name, host, origin, referer string
+		want                        bool
+	}{
+		{"trusted origin", "backend:2025", "https://notes.example.com", "", true},
+		{"trusted origin, other case", "backend:2025", "HTTPS://NOTES.EXAMPLE.COM", "", true},
+		{"trusted origin with port", "backend:2025", "http://localhost:3000", "", true},
+		{"origin of the host", "192.168.1.5:2025", "http://192.168.1.5:2025", "", true},
+		{"https origin of the host", "notes.local", "https://notes.local", "", true},
+		{"mismatched origin", "notes.local", "https://evil.example", "", false},
+		{"other port", "backend:2025", "https://notes.example.com:8443", "", false},
+		{"other scheme", "backend:2025", "http://notes.example.com", "", false},
+		{"origin wins over referer", "notes.local", "https://evil.example", "https://notes.local/", false},
+		{"matching referer", "backend:2025", "", "https://notes.example.com/notes/1?x=y", true},
+		{"referer of the host", "notes.local", "", "http://notes.local/", true},
+		{"mismatched referer", "notes.local", "", "https://evil.example/notes.local", false},
+		{"null origin with referer", "notes.local", "null", "http://notes.local/", true},
+		{"null origin", "notes.local", "null", "", false},
+		{"both missing", "notes.local", "", "", false},
+		{"relative referer", "notes.local", "", "/notes", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/api/notes", nil)
+		r.Host = tt.host
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if tt.referer != "" {
+			r.Header.Set("Referer", tt.referer)
+		}
+		if got := a.sameOrigin(r); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Only writes authenticated by the cookie are checked
+func TestMiddlewareRejectsCrossOriginWrites(t *testing.T) {
+	a := newTestAuth(t)
+	token, err := a.GenerateJWT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := a.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		if !IsWriter(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}, true)
+
+	tests := []struct {
+		name, method, origin string
+		cookie               bool
+		want                 int
+	}{
+		{"GET with cookie", http.MethodGet, "https://evil.example", true, http.StatusOK},
+		{"HEAD with cookie", http.MethodHead, "https://evil.example", true, http.StatusOK},
+		{"OPTIONS with cookie", http.MethodOptions, "https://evil.example", true, http.StatusOK},
+		{"POST with cookie", http.MethodPost, "https://evil.example", true, http.StatusForbidden},
+		{"DELETE with cookie", http.MethodDelete, "https://evil.example", true, http.StatusForbidden},
+		{"PUT with cookie, no origin", http.MethodPut, "", true, http.StatusForbidden},
+		{"POST with cookie, same origin", http.MethodPost, "http://notes.local", true, http.StatusOK},
+		{"POST with header", http.MethodPost, "https://evil.example", false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "http://notes.local/api/notes", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if tt.cookie {
+			r.AddCookie(&http.Cookie{Name: "lava_token", Value: token})
+		} else {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if tt.want == http.StatusForbidden && !strings.Contains(w.Body.String(), `"code":"cross_origin"`) {
+			t.Errorf("%s: body %s", tt.name, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod        = 30
+	totpDigits        = 6
+	totpSkew          = 1 // accept codes from one step before/after
+	recoveryCodeCount = 10
+)
+
+var ErrInvalidCode = errors.New("invalid code")
+var ErrCodeRequired = errors.New("code required")
+var ErrTOTPNotPending = errors.New("no pending TOTP enrollment")
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpCode computes the RFC 6238 code for a given time step
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// matchTOTP returns the matching time step for code, or false
+func matchTOTP(secretB32, code string, now time.Time) (int64, bool) {
+	secret, err := b32.DecodeString(strings.ToUpper(secretB32))
+	if err != nil {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for i := -totpSkew; i <= totpSkew; i++ {
+		step := current + int64(i)
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// TOTPEnabled reports whether a second factor is required for login
+func (a *Auth) TOTPEnabled() bool {
+	t, err := a.db.GetTOTP()
+	return err == nil && t.Enabled
+}
+
+// BeginTOTPEnrollment generates a new pending secret and returns it with an otpauth:// URI
+func (a *Auth) BeginTOTPEnrollment(issuer string) (string, string, error) {
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	secret := b32.EncodeToString(raw)
+	if err := a.db.SaveTOTPSecret(secret); err != nil {
+		return "", "", err
+	}
+
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("period", fmt.Sprint(totpPeriod))
+	q.Set("digits", fmt.Sprint(totpDigits))
+	uri := "otpauth://totp/" + url.PathEscape(issuer+":writer") + "?" + q.Encode()
+	return secret, uri, nil
+}
+
+// ConfirmTOTP enables a pending secret after checking a code, returns fresh recovery codes
+func (a *Auth) ConfirmTOTP(code string) ([]string, error) {
+	t, err := a.db.GetTOTP()
+	if err != nil || t.Enabled {
+		return nil, ErrTOTPNotPending
+	}
+	step, ok := matchTOTP(t.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		c := hex.EncodeToString(b)
+		codes[i] = c[:5] + "-" + c[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	if err := a.db.EnableTOTP(hashes); err != nil {
+		return nil, err
+	}
+	if _, err := a.db.AdvanceTOTPStep(step); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifySecondFactor accepts either a current TOTP code or an unused recovery code.
+// Each TOTP time step and each recovery code can only be used once.
+func (a *Auth) VerifySecondFactor(code string) error {
+	t, err := a.db.GetTOTP()
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if !t.Enabled {
+		return nil
+	}
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return ErrCodeRequired
+	}
+
+	if len(code) == totpDigits {
+		if step, ok := matchTOTP(t.Secret, code, time.Now()); ok {
+			fresh, err := a.db.AdvanceTOTPStep(step)
+			if err != nil {
+				return err
+			}
+			if fresh {
+				return nil
+			}
+			return ErrInvalidCode
+		}
+	}
+
+	used, err := a.db.UseRecoveryCode(hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// ResetTOTP removes the second factor and all recovery codes
+func (a *Auth) ResetTOTP() error {
+	return a.db.DeleteTOTP()
+}
+
+// RemainingRecoveryCodes returns the number of unused recovery codes
+func (a *Auth) RemainingRecoveryCodes() int {
+	n, _ := a.db.CountRecoveryCodes()
+	return n
+}
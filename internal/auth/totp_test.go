@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"lava-notes/internal/db"
+)
+
+func newTestAuth(t *testing.T) *Auth {
+	t.Helper()
+	database, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return New(database, "test-secret")
+}
+
+// RFC 6238 Appendix B, SHA-1. The vectors have 8 digits, our codes are the last 6.
+func TestTOTPCodeRFC6238(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, v := range vectors {
+		if got := totpCode(secret, v.unix/totpPeriod); got != v.code[2:] {
+			t.Errorf("T=%d: got %s, want %s", v.unix, got, v.code[2:])
+		}
+	}
+}
+
+func TestMatchTOTPWindow(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	secretB32 := b32.EncodeToString(secret)
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+
+	for offset := int64(-3); offset <= 3; offset++ {
+		step, ok := matchTOTP(secretB32, totpCode(secret, current+offset), now)
+		want := offset >= -totpSkew && offset <= totpSkew
+		if ok != want {
+			t.Errorf("offset %d: matched %v, want %v", offset, ok, want)
+		}
+		if ok && step != current+offset {
+			t.Errorf("offset %d: matched step %d, want %d", offset, step, current+offset)
+		}
+	}
+
+	if _, ok := matchTOTP(secretB32, "000000x", now); ok {
+		t.Error("malformed code matched")
+	}
+	if _, ok := matchTOTP("not base32!", totpCode(secret, current), now); ok {
+		t.Error("code matched an invalid secret")
+	}
+}
+
+// enroll enables TOTP and returns the raw secret, the step used to confirm and the recovery codes
+func enroll(t *testing.T, a *Auth) ([]byte, int64, []string) {
+	t.Helper()
+	secretB32, _, err := a.BeginTOTPEnrollment("Test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := b32.DecodeString(secretB32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := time.Now().Unix() / totpPeriod
+	codes, err := a.ConfirmTOTP(totpCode(secret, step))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+	return secret, step, codes
+}
+
+func TestVerifySecondFactorRejectsReusedCode(t *testing.T) {
+	a := newTestAuth(t)
+	secret, step, _ := enroll(t, a)
+
+	// The code used for enrollment is spent
+	if err := a.VerifySecondFactor(totpCode(secret, step)); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("enrollment code reused: got %v, want ErrInvalidCode", err)
+	}
+
+	// The next step is inside the window and fresh, but only once
+	next := totpCode(secret, step+1)
+	if err := a.VerifySecondFactor(next); err != nil {
+		t.Fatalf("next step: %v", err)
+	}
+	if err := a.VerifySecondFactor(next); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("next step reused: got %v, want ErrInvalidCode", err)
+	}
+
+	// Older steps can't be replayed after a newer one was used
+	if err := a.VerifySecondFactor(totpCode(secret, step-1)); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("older step: got %v, want ErrInvalidCode", err)
+	}
+
+	if err := a.VerifySecondFactor(""); !errors.Is(err, ErrCodeRequired) {
+		t.Fatalf("empty code: got %v, want ErrCodeRequired", err)
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	a := newTestAuth(t)
+	_, _, codes := enroll(t, a)
+
+	if err := a.VerifySecondFactor(codes[0]); err != nil {
+		t.Fatalf("recovery code: %v", err)
+	}
+	if err := a.VerifySecondFactor(codes[0]); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("recovery code reused: got %v, want ErrInvalidCode", err)
+	}
+	if got := a.RemainingRecoveryCodes(); got != recoveryCodeCount-1 {
+		t.Fatalf("remaining recovery codes: got %d, want %d", got, recoveryCodeCount-1)
+	}
+
+	// Codes are accepted without the dash and in upper case
+	code := codes[1][:5] + codes[1][6:]
+	if err := a.VerifySecondFactor(" " + strings.ToUpper(code) + " "); err != nil {
+		t.Fatalf("normalized recovery code: %v", err)
+	}
+
+	if err := a.VerifySecondFactor("00000-00000"); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("unknown recovery code: got %v, want ErrInvalidCode", err)
+	}
+}
+
+func TestVerifySecondFactorWithoutTOTP(t *testing.T) {
+	a := newTestAuth(t)
+	if err := a.VerifySecondFactor(""); err != nil {
+		t.Fatalf("no TOTP configured: got %v, want nil", err)
+	}
+	if _, err := a.ConfirmTOTP("123456"); !errors.Is(err, ErrTOTPNotPending) {
+		t.Fatalf("confirm without enrollment: got %v, want ErrTOTPNotPending", err)
+	}
+}
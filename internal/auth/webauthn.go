@@ -0,0 +1,383 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	challengeTTL = 5 * time.Minute
+
+	flagUserPresent  = 0x01
+	flagAttestedData = 0x40
+
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+)
+
+var ErrWebAuthn = errors.New("webauthn verification failed")
+var ErrUnknownCredential = errors.New("unknown credential")
+
+var b64url = base64.RawURLEncoding
+
+// writerUserHandle identifies the single writer account to authenticators
+var writerUserHandle = []byte("lava-writer")
+
+// webAuthnState holds relying party settings and outstanding challenges
+type webAuthnState struct {
+	mu         sync.Mutex
+	rpID       string
+	origin     string
+	challenges map[string]challenge
+}
+
+type challenge struct {
+	ceremony  string
+	expiresAt time.Time
+}
+
+// ConfigureWebAuthn sets the relying party from the public base URL (e.g. BASE_URL)
+func (a *Auth) ConfigureWebAuthn(baseURL string) error {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" {
+		return errors.New("invalid base URL")
+	}
+	a.webauthn.mu.Lock()
+	defer a.webauthn.mu.Unlock()
+	a.webauthn.rpID = u.Hostname()
+	a.webauthn.origin = u.Scheme + "://" + u.Host
+	return nil
+}
+
+func (a *Auth) newChallenge(ceremony string) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	c := b64url.EncodeToString(raw)
+
+	a.webauthn.mu.Lock()
+	defer a.webauthn.mu.Unlock()
+	now := time.Now()
+	for k, v := range a.webauthn.challenges {
+		if now.After(v.expiresAt) {
+			delete(a.webauthn.challenges, k)
+		}
+	}
+	a.webauthn.challenges[c] = challenge{ceremony: ceremony, expiresAt: now.Add(challengeTTL)}
+	return c, nil
+}
+
+// consumeChallenge removes a challenge, so each one can only be answered once
+func (a *Auth) consumeChallenge(c, ceremony string) bool {
+	a.webauthn.mu.Lock()
+	defer a.webauthn.mu.Unlock()
+	ch, ok := a.webauthn.challenges[c]
+	delete(a.webauthn.challenges, c)
+	return ok && ch.ceremony == ceremony && time.Now().Before(ch.expiresAt)
+}
+
+// CredentialResponse is the JSON form of a PublicKeyCredential sent by the browser.
+// Binary fields are base64url encoded.
+type CredentialResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject,omitempty"`
+		AuthenticatorData string `json:"authenticatorData,omitempty"`
+		Signature         string `json:"signature,omitempty"`
+		UserHandle        string `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// BeginRegistration returns PublicKeyCredentialCreationOptions for enrolling a passkey
+func (a *Auth) BeginRegistration() (map[string]interface{}, error) {
+	c, err := a.newChallenge("webauthn.create")
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := a.db.GetWebAuthnCredentials()
+	if err != nil {
+		return nil, err
+	}
+	exclude := make([]map[string]string, 0, len(creds))
+	for _, cred := range creds {
+		exclude = append(exclude, map[string]string{"type": "public-key", "id": cred.CredentialID})
+	}
+
+	return map[string]interface{}{
+		"challenge": c,
+		"rp":        map[string]string{"id": a.webauthn.rpID, "name": "Lava Notes"},
+		"user": map[string]string{
+			"id":          b64url.EncodeToString(writerUserHandle),
+			"name":        "writer",
+			"displayName": "Writer",
+		},
+		"pubKeyCredParams": []map[string]interface{}{
+			{"type": "public-key", "alg": coseAlgES256},
+			{"type": "public-key", "alg": coseAlgEdDSA},
+			{"type": "public-key", "alg": coseAlgRS256},
+		},
+		"timeout":            int(challengeTTL / time.Millisecond),
+		"attestation":        "none",
+		"excludeCredentials": exclude,
+		"authenticatorSelection": map[string]string{
+			"residentKey":      "preferred",
+			"userVerification": "preferred",
+		},
+	}, nil
+}
+
+// FinishRegistration verifies an attestation response and stores the new credential
+func (a *Auth) FinishRegistration(resp *CredentialResponse, name string) error {
+	if _, err := a.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create"); err != nil {
+		return err
+	}
+
+	attObj, err := b64url.DecodeString(resp.Response.AttestationObject)
+	if err != nil {
+		return ErrWebAuthn
+	}
+	decoded, _, err := decodeCBOR(attObj)
+	if err != nil {
+		return ErrWebAuthn
+	}
+	m, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return ErrWebAuthn
+	}
+	// Attestation statements are not verified: "none" is requested and any
+	// authenticator the writer chooses to enroll is trusted.
+	authData, ok := m["authData"].([]byte)
+	if !ok {
+		return ErrWebAuthn
+	}
+
+	parsed, err := a.parseAuthData(authData)
+	if err != nil {
+		return err
+	}
+	if parsed.flags&flagAttestedData == 0 || parsed.credentialID == nil {
+		return ErrWebAuthn
+	}
+	if _, err := parseCOSEKey(parsed.publicKey); err != nil {
+		return err
+	}
+
+	return a.db.CreateWebAuthnCredential(b64url.EncodeToString(parsed.credentialID), parsed.publicKey, parsed.signCount, name)
+}
+
+// BeginLogin returns PublicKeyCredentialRequestOptions for passkey login
+func (a *Auth) BeginLogin() (map[string]interface{}, error) {
+	c, err := a.newChallenge("webauthn.get")
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := a.db.GetWebAuthnCredentials()
+	if err != nil {
+		return nil, err
+	}
+	allow := make([]map[string]string, 0, len(creds))
+	for _, cred := range creds {
+		allow = append(allow, map[string]string{"type": "public-key", "id": cred.CredentialID})
+	}
+
+	return map[string]interface{}{
+		"challenge":        c,
+		"rpId":             a.webauthn.rpID,
+		"timeout":          int(challengeTTL / time.Millisecond),
+		"allowCredentials": allow,
+		"userVerification": "preferred",
+	}, nil
+}
+
+// FinishLogin verifies an assertion and issues a writer JWT.
+// Passkeys already prove possession of a device, so the TOTP step is not required.
+func (a *Auth) FinishLogin(resp *CredentialResponse) (string, error) {
+	rawClientData, err := a.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get")
+	if err != nil {
+		return "", err
+	}
+
+	cred, err := a.db.GetWebAuthnCredential(resp.ID)
+	if err != nil {
+		return "", ErrUnknownCredential
+	}
+
+	authData, err := b64url.DecodeString(resp.Response.AuthenticatorData)
+	if err != nil {
+		return "", ErrWebAuthn
+	}
+	sig, err := b64url.DecodeString(resp.Response.Signature)
+	if err != nil {
+		return "", ErrWebAuthn
+	}
+	parsed, err := a.parseAuthData(authData)
+	if err != nil {
+		return "", err
+	}
+
+	pub, err := parseCOSEKey(cred.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	clientHash := sha256.Sum256(rawClientData)
+	signed := append(append([]byte(nil), authData...), clientHash[:]...)
+	if !verifySignature(pub, signed, sig) {
+		return "", ErrWebAuthn
+	}
+
+	// A counter that doesn't increase indicates a cloned authenticator
+	if parsed.signCount != 0 || cred.SignCount != 0 {
+		if parsed.signCount <= cred.SignCount {
+			return "", ErrWebAuthn
+		}
+	}
+	if err := a.db.UpdateWebAuthnSignCount(cred.CredentialID, parsed.signCount); err != nil {
+		return "", err
+	}
+
+	return a.GenerateJWT()
+}
+
+// verifyClientData checks type, challenge and origin, returning the raw JSON for signing
+func (a *Auth) verifyClientData(encoded, ceremony string) ([]byte, error) {
+	raw, err := b64url.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrWebAuthn
+	}
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return nil, ErrWebAuthn
+	}
+	if cd.Type != ceremony || !a.consumeChallenge(cd.Challenge, ceremony) {
+		return nil, ErrWebAuthn
+	}
+	if a.webauthn.origin == "" || cd.Origin != a.webauthn.origin {
+		return nil, ErrWebAuthn
+	}
+	return raw, nil
+}
+
+type authenticatorData struct {
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+func (a *Auth) parseAuthData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, ErrWebAuthn
+	}
+	rpHash := sha256.Sum256([]byte(a.webauthn.rpID))
+	if !bytes.Equal(data[:32], rpHash[:]) {
+		return nil, ErrWebAuthn
+	}
+	ad := &authenticatorData{
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if ad.flags&flagUserPresent == 0 {
+		return nil, ErrWebAuthn
+	}
+
+	if ad.flags&flagAttestedData != 0 {
+		rest := data[37:]
+		if len(rest) < 18 {
+			return nil, ErrWebAuthn
+		}
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLen {
+			return nil, ErrWebAuthn
+		}
+		ad.credentialID = rest[:idLen]
+		rest = rest[idLen:]
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, ErrWebAuthn
+		}
+		ad.publicKey = rest[:len(rest)-len(after)]
+	}
+	return ad, nil
+}
+
+// parseCOSEKey converts a COSE_Key into a Go public key
+func parseCOSEKey(data []byte) (crypto.PublicKey, error) {
+	decoded, _, err := decodeCBOR(data)
+	if err != nil {
+		return nil, ErrWebAuthn
+	}
+	m, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrWebAuthn
+	}
+	alg, _ := m[int64(3)].(int64)
+
+	switch alg {
+	case coseAlgES256:
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, ErrWebAuthn
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, ErrWebAuthn
+		}
+		return pub, nil
+	case coseAlgEdDSA:
+		x, _ := m[int64(-2)].([]byte)
+		if len(x) != ed25519.PublicKeySize {
+			return nil, ErrWebAuthn
+		}
+		return ed25519.PublicKey(x), nil
+	case coseAlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, ErrWebAuthn
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	}
+	return nil, ErrWebAuthn
+}
+
+func verifySignature(pub crypto.PublicKey, signed, sig []byte) bool {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(signed)
+		return ecdsa.VerifyASN1(k, h[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, signed, sig)
+	case *rsa.PublicKey:
+		h := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig) == nil
+	}
+	return false
+}
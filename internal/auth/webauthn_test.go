@@ -0,0 +1,424 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+const (
+	testBaseURL = "https://notes.example.com"
+	testRPID    = "notes.example.com"
+)
+
+// cborPair is a map entry for cborEncode, a minimal CBOR encoder for what a
+// software authenticator sends. Entries keep their order.
+type cborPair struct {
+	key, value interface{}
+}
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+}
+
+func cborEncode(v interface{}) []byte {
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case []cborPair:
+		out := cborHead(5, uint64(len(v)))
+		for _, p := range v {
+			out = append(out, cborEncode(p.key)...)
+			out = append(out, cborEncode(p.value)...)
+		}
+		return out
+	}
+	panic("unsupported CBOR value")
+}
+
+// softAuthenticator plays the role of a security key holding one credential
+type softAuthenticator struct {
+	rpID      string
+	id        []byte
+	ec        *ecdsa.PrivateKey
+	ed        ed25519.PrivateKey
+	signCount uint32
+}
+
+func newSoftAuthenticator(t *testing.T, alg int) *softAuthenticator {
+	t.Helper()
+	s := &softAuthenticator{rpID: testRPID, id: make([]byte, 16)}
+	rand.Read(s.id)
+	var err error
+	switch alg {
+	case coseAlgES256:
+		s.ec, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case coseAlgEdDSA:
+		_, s.ed, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("unsupported algorithm %d", alg)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func (s *softAuthenticator) coseKey() []byte {
+	if s.ec != nil {
+		return cborEncode([]cborPair{
+			{1, 2}, // kty: EC2
+			{3, coseAlgES256},
+			{-1, 1}, // crv: P-256
+			{-2, s.ec.X.FillBytes(make([]byte, 32))},
+			{-3, s.ec.Y.FillBytes(make([]byte, 32))},
+		})
+	}
+	return cborEncode([]cborPair{
+		{1, 1}, // kty: OKP
+		{3, coseAlgEdDSA},
+		{-1, 6}, // crv: Ed25519
+		{-2, []byte(s.ed.Public().(ed25519.PublicKey))},
+	})
+}
+
+func (s *softAuthenticator) authData(flags byte, attested bool) []byte {
+	rpHash := sha256.Sum256([]byte(s.rpID))
+	data := append(rpHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, s.signCount)
+	if attested {
+		data = append(data, make([]byte, 16)...) // AAGUID
+		data = binary.BigEndian.AppendUint16(data, uint16(len(s.id)))
+		data = append(data, s.id...)
+		data = append(data, s.coseKey()...)
+	}
+	return data
+}
+
+func (s *softAuthenticator) sign(data []byte) []byte {
+	if s.ec != nil {
+		h := sha256.Sum256(data)
+		sig, err := ecdsa.SignASN1(rand.Reader, s.ec, h[:])
+		if err != nil {
+			panic(err)
+		}
+		return sig
+	}
+	return ed25519.Sign(s.ed, data)
+}
+
+func clientDataJSON(ceremony, challenge, origin string) []byte {
+	data, _ := json.Marshal(clientData{Type: ceremony, Challenge: challenge, Origin: origin})
+	return data
+}
+
+// register answers a creation challenge like navigator.credentials.create
+func (s *softAuthenticator) register(challenge, origin string) *CredentialResponse {
+	attObj := cborEncode([]cborPair{
+		{"fmt", "none"},
+		{"attStmt", []cborPair{}},
+		{"authData", s.authData(flagUserPresent|flagAttestedData, true)},
+	})
+	resp := &CredentialResponse{ID: b64url.EncodeToString(s.id), Type: "public-key"}
+	resp.Response.ClientDataJSON = b64url.EncodeToString(clientDataJSON("webauthn.create", challenge, origin))
+	resp.Response.AttestationObject = b64url.EncodeToString(attObj)
+	return resp
+}
+
+// login answers a request challenge like navigator.credentials.get, counting the signature
+func (s *softAuthenticator) login(challenge, origin string) *CredentialResponse {
+	s.signCount++
+	cd := clientDataJSON("webauthn.get", challenge, origin)
+	authData := s.authData(flagUserPresent, false)
+	clientHash := sha256.Sum256(cd)
+	sig := s.sign(append(append([]byte(nil), authData...), clientHash[:]...))
+
+	resp := &CredentialResponse{ID: b64url.EncodeToString(s.id), Type: "public-key"}
+	resp.Response.ClientDataJSON = b64url.EncodeToString(cd)
+	resp.Response.AuthenticatorData = b64url.EncodeToString(authData)
+	resp.Response.Signature = b64url.EncodeToString(sig)
+	resp.Response.UserHandle = b64url.EncodeToString(writerUserHandle)
+	return resp
+}
+
+func newWebAuthnTestAuth(t *testing.T) *Auth {
+	t.Helper()
+	a := newTestAuth(t)
+	if err := a.ConfigureWebAuthn(testBaseURL); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func beginRegistration(t *testing.T, a *Auth) string {
+	t.Helper()
+	opts, err := a.BeginRegistration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return opts["challenge"].(string)
+}
+
+func beginLogin(t *testing.T, a *Auth) string {
+	t.Helper()
+	opts, err := a.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return opts["challenge"].(string)
+}
+
+func registerAuthenticator(t *testing.T, a *Auth, alg int) *softAuthenticator {
+	t.Helper()
+	s := newSoftAuthenticator(t, alg)
+	if err := a.FinishRegistration(s.register(beginRegistration(t, a), testBaseURL), "key"); err != nil {
+		t.Fatalf("registration: %v", err)
+	}
+	return s
+}
+
+func TestWebAuthnRegisterAndLogin(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		alg  int
+	}{
+		{"ES256", coseAlgES256},
+		{"EdDSA", coseAlgEdDSA},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newWebAuthnTestAuth(t)
+			s := registerAuthenticator(t, a, tc.alg)
+
+			creds, err := a.db.GetWebAuthnCredentials()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(creds) != 1 || creds[0].CredentialID != b64url.EncodeToString(s.id) {
+				t.Fatalf("stored credentials: %+v", creds)
+			}
+
+			for i := 0; i < 2; i++ {
+				token, err := a.FinishLogin(s.login(beginLogin(t, a), testBaseURL))
+				if err != nil {
+					t.Fatalf("login %d: %v", i+1, err)
+				}
+				if _, err := a.ValidateJWT(token); err != nil {
+					t.Fatalf("login %d issued an invalid token: %v", i+1, err)
+				}
+			}
+
+			cred, err := a.db.GetWebAuthnCredential(b64url.EncodeToString(s.id))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cred.SignCount != s.signCount {
+				t.Fatalf("stored sign count %d, want %d", cred.SignCount, s.signCount)
+			}
+		})
+	}
+}
+
+func TestWebAuthnRejectsWrongOrigin(t *testing.T) {
+	a := newWebAuthnTestAuth(t)
+	s := newSoftAuthenticator(t, coseAlgES256)
+	if err := a.FinishRegistration(s.register(beginRegistration(t, a), "https://evil.example.com"), "key"); !errors.Is(err, ErrWebAuthn) {
+		t.Fatalf("registration from another origin: got %v, want ErrWebAuthn", err)
+	}
+
+	s = registerAuthenticator(t, a, coseAlgES256)
+	if _, err := a.FinishLogin(s.login(beginLogin(t, a), "http://notes.example.com")); !errors.Is(err, ErrWebAuthn) {
+		t.Fatalf("login from another origin: got %v, want ErrWebAuthn", err)
+	}
+}
+
+func TestWebAuthnRejectsWrongRPIDHash(t *testing.T) {
+	a := newWebAuthnTestAuth(t)
+	s := newSoftAuthenticator(t, coseAlgEdDSA)
+	s.rpID = "evil.example.com"
+	if err := a.FinishRegistration(s.register(beginRegistration(t, a), testBaseURL), "key"); !errors.Is(err, ErrWebAuthn) {
+		t.Fatalf("registration for another RP: got %v, want ErrWebAuthn", err)
+	}
+
+	s = registerAuthenticator(t, a, coseAlgEdDSA)
+	s.rpID = "example.com"
+	if _, err := a.FinishLogin(s.login(beginLogin(t, a), testBaseURL)); !errors.Is(err, ErrWebAuthn) {
+		t.Fatalf("login for another RP: got %v, want ErrWebAuthn", err)
+	}
+}
+
+func TestWebAuthnChallenges(t *testing.T) {
+	a := newWebAuthnTestAuth(t)
+	s := registerAuthenticator(t, a, coseAlgES256)
+
+	// A challenge can only be answered once
+	c := beginLogin(t, a)
+	if _, err := a.FinishLogin(s.login(c, testBaseURL)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.FinishLogin(s.login(c, testBaseURL)); !errors.Is(err, ErrWebAuthn) {
+		t.Fatalf("reused challenge: got %v, want ErrWebAuthn", err)
+	}
+
+	// Challenges expire
+	c = beginLogin(t, a)
+	a.webauthn.mu.Lock()
+	a.webauthn.challenges[c] = challenge{ceremony: "webauthn.get", expiresAt: time.Now().Add(-time.Second)}
+	a.webauthn.mu.Unlock()
+	if _, err := a.FinishLogin(s.login(c, testBaseURL)); !errors.Is(err, ErrWebAuthn) {
+		t.Fatalf("expired challenge: got %v, want ErrWebAuthn", err)
+	}
+
+	// Unknown challenges and those of the other ceremony are rejected
+	if _, err := a.FinishLogin(s.login("unknown", testBaseURL)); !errors.Is(err, ErrWebAuthn) {
+		t.Fatalf("unknown challenge: got %v, want ErrWebAuthn", err)
+	}
+	if _, err := a.FinishLogin(s.login(beginRegistration(t, a), testBaseURL)); !errors.Is(err, ErrWebAuthn) {
+		t.Fatalf("registration challenge used for login: got %v, want ErrWebAuthn", err)
+	}
+}
+
+func TestWebAuthnRejectsSignCountRegression(t *testing.T) {
+	a := newWebAuthnTestAuth(t)
+	s := registerAuthenticator(t, a, coseAlgEdDSA)
+	s.signCount = 10
+	if _, err := a.FinishLogin(s.login(beginLogin(t, a), testBaseURL)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A clone still at the old counter value
+	s.signCount = 5
+	if _, err := a.FinishLogin(s.login(beginLogin(t, a), testBaseURL)); !errors.Is(err, ErrWebAuthn) {
+		t.Fatalf("lower sign count: got %v, want ErrWebAuthn", err)
+	}
+	s.signCount = 10
+	if _, err := a.FinishLogin(s.login(beginLogin(t, a), testBaseURL)); !errors.Is(err, ErrWebAuthn) {
+		t.Fatalf("repeated sign count: got %v, want ErrWebAuthn", err)
+	}
+
+	cred, err := a.db.GetWebAuthnCredential(b64url.EncodeToString(s.id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.SignCount != 11 {
+		t.Fatalf("stored sign count %d, want 11", cred.SignCount)
+	}
+}
+
+func TestWebAuthnRejectsBadSignature(t *testing.T) {
+	a := newWebAuthnTestAuth(t)
+	s := registerAuthenticator(t, a, coseAlgES256)
+	resp := s.login(beginLogin(t, a), testBaseURL)
+
+	// A different key answering for the registered credential
+	other := newSoftAuthenticator(t, coseAlgES256)
+	other.id = s.id
+	resp.Response.Signature = other.login("", testBaseURL).Response.Signature
+	if _, err := a.FinishLogin(resp); !errors.Is(err, ErrWebAuthn) {
+		t.Fatalf("signature of another key: got %v, want ErrWebAuthn", err)
+	}
+
+	unknown := newSoftAuthenticator(t, coseAlgES256)
+	if _, err := a.FinishLogin(unknown.login(beginLogin(t, a), testBaseURL)); !errors.Is(err, ErrUnknownCredential) {
+		t.Fatalf("unregistered credential: got %v, want ErrUnknownCredential", err)
+	}
+}
+
+func TestDecodeCBORMalformed(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":                 {},
+		"truncated uint16":      {0x19, 0x01},
+		"truncated uint64":      {0x1b, 0, 0, 0, 0},
+		"truncated bytes":       {0x45, 1, 2},
+		"truncated text":        {0x63, 'a'},
+		"truncated array":       {0x82, 0x01},
+		"truncated map":         {0xa1, 0x01},
+		"oversized array":       {0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"oversized bytes":       {0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"integer overflow":      {0x1b, 0x80, 0, 0, 0, 0, 0, 0, 0},
+		"indefinite length":     {0x5f, 0x41, 0x00, 0xff},
+		"float":                 {0xf9, 0x3c, 0x00},
+		"tag":                   {0xc2, 0x41, 0x01},
+		"array map key":         {0xa1, 0x80, 0x01},
+		"reserved info":         {0x1c},
+		"nested past the limit": append(repeat(0x81, 20), 0x01),
+	} {
+		if _, _, err := decodeCBOR(data); err == nil {
+			t.Errorf("%s: decoded without error", name)
+		}
+	}
+
+	v, rest, err := decodeCBOR([]byte{0xa1, 0x21, 0x42, 1, 2, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := v.(map[interface{}]interface{}); !ok || string(m[int64(-2)].([]byte)) != "\x01\x02" {
+		t.Fatalf("decoded %#v", v)
+	}
+	if len(rest) != 1 {
+		t.Fatalf("%d bytes left, want 1", len(rest))
+	}
+}
+
+func repeat(b byte, n int) []byte {
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = b
+	}
+	return out
+}
+
+func TestWebAuthnRejectsMalformedAttestation(t *testing.T) {
+	a := newWebAuthnTestAuth(t)
+	s := newSoftAuthenticator(t, coseAlgES256)
+	valid := s.authData(flagUserPresent|flagAttestedData, true)
+
+	for name, attObj := range map[string][]byte{
+		"truncated object":   cborEncode([]cborPair{{"fmt", "none"}, {"authData", valid}})[:20],
+		"not a map":          cborEncode("none"),
+		"missing authData":   cborEncode([]cborPair{{"fmt", "none"}}),
+		"short authData":     cborEncode([]cborPair{{"authData", valid[:36]}}),
+		"truncated cred ID":  cborEncode([]cborPair{{"authData", valid[:37+18+4]}}),
+		"truncated COSE key": cborEncode([]cborPair{{"authData", valid[:len(valid)-5]}}),
+		"no attested data":   cborEncode([]cborPair{{"authData", s.authData(flagUserPresent, false)}}),
+		"bad COSE key": cborEncode([]cborPair{{"authData", append(append([]byte(nil), valid[:37+18+len(s.id)]...),
+			cborEncode([]cborPair{{3, coseAlgES256}, {-2, []byte{1}}, {-3, []byte{2}}})...)}}),
+	} {
+		resp := s.register(beginRegistration(t, a), testBaseURL)
+		resp.Response.AttestationObject = b64url.EncodeToString(attObj)
+		if err := a.FinishRegistration(resp, "key"); !errors.Is(err, ErrWebAuthn) {
+			t.Errorf("%s: got %v, want ErrWebAuthn", name, err)
+		}
+	}
+
+	creds, err := a.db.GetWebAuthnCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(creds) != 0 {
+		t.Fatalf("%d credentials stored from malformed attestations", len(creds))
+	}
+}
@@ -0,0 +1,302 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"lava-notes/internal/db"
+)
+
+// Snapshot files are named after their UTC creation time
+const (
+	filePrefix = "lava-"
+	fileSuffix = ".db"
+	timeLayout = "20060102-150405"
+)
+
+// Policy is the number of hourly, daily and weekly snapshots kept.
+// The newest snapshot of each period is kept, the newest overall always is.
+type Policy struct {
+	Hourly int
+	Daily  int
+	Weekly int
+}
+
+// DefaultPolicy keeps a day of hourly, a week of daily and a month of weekly snapshots
+var DefaultPolicy = Policy{Hourly: 24, Daily: 7, Weekly: 4}
+
+type Snapshot struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"-"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	Remote    string    `json:"remote,omitempty"` // name of the off-site copy
+}
+
+// Manager takes snapshots of the live database into a directory
+type Manager struct {
+	db     *db.DB
+	dir    string
+	policy Policy
+	remote *Remote
+	mu     sync.Mutex // one snapshot or prune at a time
+}
+
+// New creates a manager and, when interval is positive, takes scheduled snapshots
+func New(database *db.DB, dir string, policy Policy, interval time.Duration) *Manager {
+	m := &Manager{
+		db:     database,
+		dir:    dir,
+		policy: policy,
+	}
+
+	if interval > 0 {
+		go m.snapshotLoop(interval)
+	}
+
+	return m
+}
+
+func (m *Manager) snapshotLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := m.Snapshot(); err != nil {
+			log.Printf("Scheduled backup failed: %v", err)
+		}
+	}
+}
+
+// SetRemote uploads every following snapshot to off-site storage
+func (m *Manager) SetRemote(r *Remote) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remote = r
+}
+
+// Snapshot writes a consistent copy of the database, uploads it when a
+// remote is set and prunes old snapshots
+func (m *Manager) Snapshot() (*Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	name := filePrefix + now.Format(timeLayout) + fileSuffix
+	path := filepath.Join(m.dir, name)
+	if err := Write(m.db, path); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.prune(); err != nil {
+		log.Printf("Failed to prune backups: %v", err)
+	}
+	snapshot := &Snapshot{Name: name, Path: path, Size: info.Size(), CreatedAt: now}
+
+	// The local snapshot is still useful when the upload fails
+	if m.remote != nil {
+		remoteName, err := m.remote.Upload(*snapshot)
+		if err != nil {
+			log.Printf("Off-site backup of %s failed: %v", name, err)
+		}
+		snapshot.Remote = remoteName
+	}
+	return snapshot, nil
+}
+
+// List returns the snapshots in the backup directory, newest first
+func (m *Manager) List() ([]Snapshot, error) {
+	entries, err := os.ReadDir(m.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]Snapshot, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		created, ok := parseName(name)
+		if e.IsDir() || !ok {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{Name: name, Path: filepath.Join(m.dir, name), Size: info.Size(), CreatedAt: created})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+// parseName returns the creation time of a snapshot file name
+func parseName(name string) (time.Time, bool) {
+	name = strings.TrimSuffix(name, encryptedSuffix)
+	if !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+		return time.Time{}, false
+	}
+	created, err := time.Parse(timeLayout, strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix))
+	return created, err == nil
+}
+
+// prune deletes snapshots not kept by the retention policy, caller must hold m.mu
+func (m *Manager) prune() (int, error) {
+	snapshots, err := m.List()
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, s := range Expired(snapshots, m.policy) {
+		if err := os.Remove(s.Path); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// Expired returns the snapshots (sorted newest first) the policy doesn't keep
+func Expired(snapshots []Snapshot, policy Policy) []Snapshot {
+	keep := make(map[string]bool)
+	if len(snapshots) > 0 {
+		keep[snapshots[0].Name] = true
+	}
+
+	periods := []struct {
+		count  int
+		period func(time.Time) string
+	}{
+		{policy.Hourly, func(t time.Time) string { return t.Format("2006010215") }},
+		{policy.Daily, func(t time.Time) string { return t.Format("20060102") }},
+		{policy.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%02d", year, week)
+		}},
+	}
+	for _, p := range periods {
+		seen := make(map[string]bool)
+		for _, s := range snapshots {
+			if len(seen) >= p.count {
+				break
+			}
+			key := p.period(s.CreatedAt)
+			if !seen[key] {
+				seen[key] = true
+				keep[s.Name] = true
+			}
+		}
+	}
+
+	var expired []Snapshot
+	for _, s := range snapshots {
+		if !keep[s.Name] {
+			expired = append(expired, s)
+		}
+	}
+	return expired
+}
+
+// Write takes a consistent snapshot of the live database and verifies it.
+// The snapshot is written next to path first, so path never holds a partial file.
+func Write(database *db.DB, path string) error {
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if err := database.Backup(tmp); err != nil {
+		return fmt.Errorf("snapshot failed: %w", err)
+	}
+	if err := db.VerifyFile(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Restore replaces the database file at dbPath with a snapshot after checking
+// its integrity. The server must not be running. The replaced database is kept
+// next to it, returns its path.
+func Restore(snapshotPath, dbPath string) (string, error) {
+	if err := db.VerifyFile(snapshotPath); err != nil {
+		return "", fmt.Errorf("%s: %w", snapshotPath, err)
+	}
+
+	// Copy first so a failure can't leave dbPath missing
+	tmp := dbPath + ".restore"
+	if err := copyFile(snapshotPath, tmp); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := db.VerifyFile(tmp); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	previous := ""
+	if _, err := os.Stat(dbPath); err == nil {
+		previous = dbPath + ".pre-restore-" + time.Now().UTC().Format(timeLayout)
+		if err := os.Rename(dbPath, previous); err != nil {
+			os.Remove(tmp)
+			return "", err
+		}
+	}
+	// Journal files of the replaced database must not be applied to the snapshot
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if _, err := os.Stat(dbPath + suffix); err == nil && previous != "" {
+			os.Rename(dbPath+suffix, previous+suffix)
+		}
+	}
+
+	if err := os.Rename(tmp, dbPath); err != nil {
+		if previous != "" {
+			os.Rename(previous, dbPath)
+		}
+		return "", err
+	}
+	return previous, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,100 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// Encrypted snapshots start with encMagic, followed by the salt, the nonce
+// and the AES-256-GCM ciphertext. The key is derived with PBKDF2-HMAC-SHA256.
+var encMagic = []byte("LAVABAK1")
+
+const (
+	saltSize        = 16
+	pbkdf2Rounds    = 600000
+	encryptedSuffix = ".enc"
+)
+
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted backup")
+
+func encrypt(plaintext []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(encMagic)+saltSize+len(nonce)+len(plaintext)+gcm.Overhead())
+	out = append(out, encMagic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	// The header is authenticated along with the data. Seal appends to out,
+	// so the additional data must be a copy that doesn't overlap it.
+	aad := append([]byte(nil), out...)
+	return gcm.Seal(out, nonce, plaintext, aad), nil
+}
+
+func decrypt(data []byte, passphrase string) ([]byte, error) {
+	if !bytes.HasPrefix(data, encMagic) || len(data) < len(encMagic)+saltSize {
+		return nil, errors.New("not an encrypted backup")
+	}
+	salt := data[len(encMagic) : len(encMagic)+saltSize]
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	headerSize := len(encMagic) + saltSize + gcm.NonceSize()
+	if len(data) < headerSize+gcm.Overhead() {
+		return nil, ErrWrongPassphrase
+	}
+	nonce := data[len(encMagic)+saltSize : headerSize]
+	plaintext, err := gcm.Open(nil, nonce, data[headerSize:], data[:headerSize])
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
+
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2([]byte(passphrase), salt, pbkdf2Rounds, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2 implements RFC 8018 with HMAC-SHA256
+func pbkdf2(password, salt []byte, rounds, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.Write(prf, binary.BigEndian, block)
+		u := prf.Sum(nil)
+		t := append([]byte{}, u...)
+		for i := 1; i < rounds; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
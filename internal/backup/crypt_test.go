@@ -0,0 +1,88 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// RFC 7914 section 11, PBKDF2-HMAC-SHA256
+func TestPBKDF2Vectors(t *testing.T) {
+	vectors := []struct {
+		password, salt string
+		rounds         int
+		key            string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+	for _, v := range vectors {
+		if got := hex.EncodeToString(pbkdf2([]byte(v.password), []byte(v.salt), v.rounds, 64)); got != v.key {
+			t.Errorf("pbkdf2(%q, %q, %d) = %s, want %s", v.password, v.salt, v.rounds, got, v.key)
+		}
+	}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	plaintext := []byte("SQLite format 3\x00 and the rest of a snapshot")
+	data, err := encrypt(plaintext, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, encMagic) || bytes.Contains(data, plaintext[:15]) {
+		t.Fatal("encrypted snapshot isn't opaque")
+	}
+
+	got, err := decrypt(data, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("decrypted %q, want %q", got, plaintext)
+	}
+
+	// A fresh salt and nonce every time
+	again, err := encrypt(plaintext, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(again, data) {
+		t.Fatal("two encryptions of the same snapshot are identical")
+	}
+}
+
+func TestDecryptRejectsWrongPassphraseAndTampering(t *testing.T) {
+	data, err := encrypt([]byte("snapshot"), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decrypt(data, "battery staple"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("wrong passphrase: got %v, want ErrWrongPassphrase", err)
+	}
+
+	saltStart := len(encMagic)
+	nonceStart := saltStart + saltSize
+	for name, offset := range map[string]int{
+		"salt":       saltStart,
+		"nonce":      nonceStart,
+		"ciphertext": nonceStart + 12,
+		"tag":        len(data) - 1,
+	} {
+		tampered := append([]byte(nil), data...)
+		tampered[offset] ^= 0x01
+		if _, err := decrypt(tampered, "correct horse"); !errors.Is(err, ErrWrongPassphrase) {
+			t.Errorf("tampered %s: got %v, want ErrWrongPassphrase", name, err)
+		}
+	}
+
+	if _, err := decrypt(data[:nonceStart+12], "correct horse"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("truncated: got %v, want ErrWrongPassphrase", err)
+	}
+	if _, err := decrypt(data[len(encMagic):], "correct horse"); err == nil {
+		t.Error("data without the header decrypted")
+	}
+	if _, err := decrypt([]byte("SQLite format 3\x00"), "correct horse"); err == nil {
+		t.Error("plain snapshot decrypted")
+	}
+}
@@ -0,0 +1,154 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// RemoteConfig describes off-site storage, see RemoteConfigFromEnv
+type RemoteConfig struct {
+	Endpoint   string
+	Bucket     string
+	Region     string
+	AccessKey  string
+	SecretKey  string
+	Prefix     string // key prefix of snapshots in the bucket
+	Passphrase string // encrypts snapshots client-side when set
+	Policy     Policy // retention of remote snapshots
+}
+
+// RemoteConfigFromEnv reads BACKUP_S3_* and BACKUP_PASSPHRASE, ok is false when no endpoint is set
+func RemoteConfigFromEnv(policy Policy) (RemoteConfig, bool) {
+	cfg := RemoteConfig{
+		Endpoint:   os.Getenv("BACKUP_S3_ENDPOINT"),
+		Bucket:     os.Getenv("BACKUP_S3_BUCKET"),
+		Region:     os.Getenv("BACKUP_S3_REGION"),
+		AccessKey:  os.Getenv("BACKUP_S3_ACCESS_KEY"),
+		SecretKey:  os.Getenv("BACKUP_S3_SECRET_KEY"),
+		Prefix:     os.Getenv("BACKUP_S3_PREFIX"),
+		Passphrase: os.Getenv("BACKUP_PASSPHRASE"),
+		Policy:     policy,
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "lava-notes/"
+	}
+	return cfg, cfg.Endpoint != ""
+}
+
+// Remote stores snapshots in an S3-compatible bucket
+type Remote struct {
+	s3         *S3
+	prefix     string
+	passphrase string
+	policy     Policy
+}
+
+func NewRemote(cfg RemoteConfig) (*Remote, error) {
+	s3, err := NewS3(cfg.Endpoint, cfg.Bucket, cfg.Region, cfg.AccessKey, cfg.SecretKey)
+	if err != nil {
+		return nil, err
+	}
+	prefix := cfg.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &Remote{
+		s3:         s3,
+		prefix:     prefix,
+		passphrase: cfg.Passphrase,
+		policy:     cfg.Policy,
+	}, nil
+}
+
+// Upload stores a local snapshot, encrypted when a passphrase is set, and prunes old remote snapshots
+func (r *Remote) Upload(s Snapshot) (string, error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return "", err
+	}
+	name := s.Name
+	if r.passphrase != "" {
+		if data, err = encrypt(data, r.passphrase); err != nil {
+			return "", err
+		}
+		name += encryptedSuffix
+	}
+	if err := r.s3.Put(r.prefix+name, data); err != nil {
+		return "", err
+	}
+	if _, err := r.Prune(); err != nil {
+		return name, fmt.Errorf("uploaded, but pruning failed: %w", err)
+	}
+	return name, nil
+}
+
+// List returns the remote snapshots, newest first
+func (r *Remote) List() ([]Snapshot, error) {
+	objects, err := r.s3.List(r.prefix)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]Snapshot, 0, len(objects))
+	for _, o := range objects {
+		name := strings.TrimPrefix(o.Key, r.prefix)
+		created, ok := parseName(name)
+		if strings.Contains(name, "/") || !ok {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{Name: name, Path: o.Key, Size: o.Size, CreatedAt: created})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+// Prune deletes remote snapshots not kept by the retention policy, returns the number deleted
+func (r *Remote) Prune() (int, error) {
+	snapshots, err := r.List()
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, s := range Expired(snapshots, r.policy) {
+		if err := r.s3.Delete(s.Path); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// Download fetches a remote snapshot by name, or the newest one for "latest",
+// decrypts it if needed and writes it to dst. Returns the snapshot name.
+func (r *Remote) Download(name, dst string) (string, error) {
+	if name == "" || name == "latest" {
+		snapshots, err := r.List()
+		if err != nil {
+			return "", err
+		}
+		if len(snapshots) == 0 {
+			return "", errors.New("no remote snapshots")
+		}
+		name = snapshots[0].Name
+	}
+	name = path.Base(name)
+
+	data, err := r.s3.Get(r.prefix + name)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(name, encryptedSuffix) {
+		if r.passphrase == "" {
+			return "", errors.New("snapshot is encrypted, set BACKUP_PASSPHRASE")
+		}
+		if data, err = decrypt(data, r.passphrase); err != nil {
+			return "", err
+		}
+	}
+	return name, os.WriteFile(dst, data, 0644)
+}
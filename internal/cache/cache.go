@@ -2,102 +2,354 @@ package cache
 
 import (
 	"container/list"
+	"reflect"
+	"sort"
 	"sync"
 	"time"
+)
 
-	"lava-notes/internal/models"
+// Defaults, overridable with --cache-entries, --cache-mb and --cache-ttl
+const (
+	DefaultMaxEntries = 150
+	DefaultMaxBytes   = 32 << 20
+	DefaultTTL        = time.Hour
 )
 
-const MaxCacheSize = 150
+// defaultEntrySize is used for values that can't report their size
+const defaultEntrySize = 64
+
+// Sizer is implemented by values that know their approximate memory footprint
+type Sizer interface {
+	Size() int64
+}
+
+type Options[V any] struct {
+	MaxEntries int           // 0 means unlimited
+	MaxBytes   int64         // 0 means unlimited
+	TTL        time.Duration // 0 means entries never expire
+	StaleTTL   time.Duration // how long expired entries may be served by GetOrLoadStale
+	SizeOf     func(V) int64 // defaults to Sizer, len() of strings and byte slices
+}
+
+// Loader loads a value on a cache miss and returns the tags it depends on
+type Loader[V any] func() (V, []string, error)
+
+// call is an in-flight load shared by concurrent callers of the same key
+type call[V any] struct {
+	wg    sync.WaitGroup
+	value V
+	err   error
+}
+
+type Stats struct {
+	Entries    int     `json:"entries"`
+	MaxEntries int     `json:"max_entries"`
+	Bytes      int64   `json:"bytes"`
+	MaxBytes   int64   `json:"max_bytes"`
+	Hits       uint64  `json:"hits"`
+	Misses     uint64  `json:"misses"`
+	HitRate    float64 `json:"hit_rate"`
+	Evictions  uint64  `json:"evictions"`
+}
 
-type cacheEntry struct {
-	key       string
-	note      *models.Note
-	timestamp time.Time
+// KeyStats describes a single entry, as reported by TopKeys
+type KeyStats[K comparable] struct {
+	Key   K      `json:"key"`
+	Hits  uint64 `json:"hits"`
+	Bytes int64  `json:"bytes"`
 }
 
-type Cache struct {
-	mu       sync.RWMutex
-	items    map[string]*list.Element
-	order    *list.List
-	maxSize  int
+type cacheEntry[K comparable, V any] struct {
+	key       K
+	value     V
+	size      int64
+	expiresAt time.Time
+	tags      []string
+	hits      uint64
 }
 
-func New() *Cache {
-	return &Cache{
-		items:   make(map[string]*list.Element),
-		order:   list.New(),
-		maxSize: MaxCacheSize,
+// Cache is an LRU cache bounded by entry count and total byte size
+type Cache[K comparable, V any] struct {
+	mu    sync.Mutex
+	items map[K]*list.Element
+	order *list.List // front is most recently used
+	tags  map[string]map[K]struct{}
+	calls map[K]*call[V]
+	opts  Options[V]
+	bytes int64
+	epoch uint64 // incremented by every invalidation
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+func New[K comparable, V any](opts Options[V]) *Cache[K, V] {
+	if opts.SizeOf == nil {
+		opts.SizeOf = sizeOf[V]
+	}
+	return &Cache[K, V]{
+		items: make(map[K]*list.Element),
+		order: list.New(),
+		tags:  make(map[string]map[K]struct{}),
+		calls: make(map[K]*call[V]),
+		opts:  opts,
 	}
 }
 
-func (c *Cache) Get(key string) (*models.Note, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+func sizeOf[V any](v V) int64 {
+	switch val := any(v).(type) {
+	case Sizer:
+		return val.Size()
+	case string:
+		return int64(len(val))
+	case []byte:
+		return int64(len(val))
+	}
 
-	if elem, ok := c.items[key]; ok {
-		entry := elem.Value.(*cacheEntry)
-		return entry.note, true
+	// Slices of sized values, e.g. []models.NoteListItem
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice && rv.Type().Elem().Implements(reflect.TypeOf((*Sizer)(nil)).Elem()) {
+		var total int64
+		for i := 0; i < rv.Len(); i++ {
+			total += rv.Index(i).Interface().(Sizer).Size()
+		}
+		return total + defaultEntrySize
+	}
+	return defaultEntrySize
+}
+
+// lookup finds an entry and reports whether it is within its TTL.
+// Expired entries are kept for StaleTTL, caller must hold the lock.
+func (c *Cache[K, V]) lookup(key K) (V, bool, bool) {
+	var zero V
+	elem, ok := c.items[key]
+	if !ok {
+		return zero, false, false
+	}
+	entry := elem.Value.(*cacheEntry[K, V])
+	now := time.Now()
+	if entry.expiresAt.IsZero() || now.Before(entry.expiresAt) {
+		c.order.MoveToFront(elem)
+		entry.hits++
+		return entry.value, true, true
+	}
+	if now.Before(entry.expiresAt.Add(c.opts.StaleTTL)) {
+		entry.hits++
+		return entry.value, false, true
+	}
+	c.remove(elem)
+	return zero, false, false
+}
+
+// GetOrLoad returns a cached value or runs load to fill it. Concurrent
+// misses for the same key share a single load instead of each hitting the database.
+func (c *Cache[K, V]) GetOrLoad(key K, load Loader[V]) (V, error) {
+	c.mu.Lock()
+	if value, fresh, ok := c.lookup(key); ok && fresh {
+		c.hits++
+		c.mu.Unlock()
+		return value, nil
+	}
+	c.misses++
+	cl := c.startLoad(key, load)
+	c.mu.Unlock()
+
+	cl.wg.Wait()
+	return cl.value, cl.err
+}
+
+// GetOrLoadStale is like GetOrLoad but serves an expired entry within StaleTTL
+// immediately, refreshing it in the background (stale-while-revalidate)
+func (c *Cache[K, V]) GetOrLoadStale(key K, load Loader[V]) (V, error) {
+	c.mu.Lock()
+	value, fresh, ok := c.lookup(key)
+	if ok {
+		c.hits++
+		if !fresh {
+			c.startLoad(key, load)
+		}
+		c.mu.Unlock()
+		return value, nil
+	}
+	c.misses++
+	cl := c.startLoad(key, load)
+	c.mu.Unlock()
+
+	cl.wg.Wait()
+	return cl.value, cl.err
+}
+
+// startLoad joins an in-flight load for key or starts a new one, caller must hold the lock
+func (c *Cache[K, V]) startLoad(key K, load Loader[V]) *call[V] {
+	if cl, ok := c.calls[key]; ok {
+		return cl
 	}
-	return nil, false
+	cl := &call[V]{}
+	cl.wg.Add(1)
+	c.calls[key] = cl
+	epoch := c.epoch
+
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.calls, key)
+			c.mu.Unlock()
+			cl.wg.Done()
+		}()
+
+		var tags []string
+		cl.value, tags, cl.err = load()
+		if cl.err == nil {
+			c.set(key, cl.value, tags, &epoch)
+		}
+	}()
+	return cl
 }
 
-func (c *Cache) Set(key string, note *models.Note) {
+func (c *Cache[K, V]) set(key K, value V, tags []string, epoch *uint64) bool {
+	size := c.opts.SizeOf(value)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if epoch != nil && *epoch != c.epoch {
+		return false
+	}
+
 	if elem, ok := c.items[key]; ok {
-		c.order.MoveToFront(elem)
-		entry := elem.Value.(*cacheEntry)
-		entry.note = note
-		entry.timestamp = time.Now()
-		return
+		c.remove(elem)
 	}
 
-	if c.order.Len() >= c.maxSize {
+	// A value larger than the whole cache would only evict everything else
+	if c.opts.MaxBytes > 0 && size > c.opts.MaxBytes {
+		return false
+	}
+
+	entry := &cacheEntry[K, V]{
+		key:   key,
+		value: value,
+		size:  size,
+		tags:  tags,
+	}
+	if c.opts.TTL > 0 {
+		entry.expiresAt = time.Now().Add(c.opts.TTL)
+	}
+	c.items[key] = c.order.PushFront(entry)
+	c.bytes += size
+	for _, tag := range tags {
+		if c.tags[tag] == nil {
+			c.tags[tag] = make(map[K]struct{})
+		}
+		c.tags[tag][key] = struct{}{}
+	}
+
+	for c.overLimit() {
 		oldest := c.order.Back()
-		if oldest != nil {
-			entry := oldest.Value.(*cacheEntry)
-			delete(c.items, entry.key)
-			c.order.Remove(oldest)
+		if oldest == nil {
+			break
 		}
+		c.remove(oldest)
+		c.evictions++
 	}
+	return true
+}
 
-	entry := &cacheEntry{
-		key:       key,
-		note:      note,
-		timestamp: time.Now(),
+func (c *Cache[K, V]) overLimit() bool {
+	return (c.opts.MaxEntries > 0 && c.order.Len() > c.opts.MaxEntries) ||
+		(c.opts.MaxBytes > 0 && c.bytes > c.opts.MaxBytes)
+}
+
+// remove deletes an element, caller must hold the lock
+func (c *Cache[K, V]) remove(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry[K, V])
+	delete(c.items, entry.key)
+	c.order.Remove(elem)
+	c.bytes -= entry.size
+	for _, tag := range entry.tags {
+		delete(c.tags[tag], entry.key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
 	}
-	elem := c.order.PushFront(entry)
-	c.items[key] = elem
 }
 
-func (c *Cache) Invalidate(key string) {
+func (c *Cache[K, V]) Invalidate(key K) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.epoch++
 	if elem, ok := c.items[key]; ok {
-		delete(c.items, key)
-		c.order.Remove(elem)
+		c.remove(elem)
 	}
 }
 
-func (c *Cache) InvalidateByPrefix(prefix string) {
+// InvalidateTag removes all entries stored with the tag, returns the number removed
+func (c *Cache[K, V]) InvalidateTag(tag string) int {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	for key, elem := range c.items {
-		if len(key) >= len(prefix) && key[:len(prefix)] == prefix {
-			delete(c.items, key)
-			c.order.Remove(elem)
+	c.epoch++
+	removed := 0
+	for key := range c.tags[tag] {
+		if elem, ok := c.items[key]; ok {
+			c.remove(elem)
+			removed++
 		}
 	}
+	return removed
+}
+
+func (c *Cache[K, V]) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := Stats{
+		Entries:    c.order.Len(),
+		MaxEntries: c.opts.MaxEntries,
+		Bytes:      c.bytes,
+		MaxBytes:   c.opts.MaxBytes,
+		Hits:       c.hits,
+		Misses:     c.misses,
+		Evictions:  c.evictions,
+	}
+	if total := c.hits + c.misses; total > 0 {
+		stats.HitRate = float64(c.hits) / float64(total)
+	}
+	return stats
+}
+
+// TopKeys returns up to n entries with the most hits
+func (c *Cache[K, V]) TopKeys(n int) []KeyStats[K] {
+	c.mu.Lock()
+	keys := make([]KeyStats[K], 0, c.order.Len())
+	for e := c.order.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*cacheEntry[K, V])
+		keys = append(keys, KeyStats[K]{Key: entry.key, Hits: entry.hits, Bytes: entry.size})
+	}
+	c.mu.Unlock()
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].Hits > keys[j].Hits
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// TTL returns the default lifetime of entries
+func (c *Cache[K, V]) TTL() time.Duration {
+	return c.opts.TTL
 }
 
-func (c *Cache) Clear() {
+func (c *Cache[K, V]) Clear() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.items = make(map[string]*list.Element)
+	c.items = make(map[K]*list.Element)
 	c.order = list.New()
+	c.tags = make(map[string]map[K]struct{})
+	c.bytes = 0
+	c.epoch++
 }
@@ -0,0 +1,320 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// counter is a loader returning "<key> v<n>" on its n-th call
+type counter struct {
+	calls int
+	tags  []string
+}
+
+func (l *counter) loader(key string) Loader[string] {
+	return func() (string, []string, error) {
+		l.calls++
+		return fmt.Sprintf("%s v%d", key, l.calls), l.tags, nil
+	}
+}
+
+// get loads key through c, failing on an error
+func get(t *testing.T, c *Cache[string, string], key string, l *counter) string {
+	t.Helper()
+	v, err := c.GetOrLoad(key, l.loader(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// has reports whether key is cached, without counting a hit
+func has(c *Cache[string, string], key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.items[key]
+	return ok
+}
+
+func TestGetPromotes(t *testing.T) {
+	c := New[string, string](Options[string]{MaxEntries: 2})
+	l := &counter{}
+	get(t, c, "a", l)
+	get(t, c, "b", l)
+	if v := get(t, c, "a", l); v != "a v1" || l.calls != 2 {
+		t.Fatalf("hit: got %q after %d loads", v, l.calls)
+	}
+
+	// "b" is now the least recently used
+	get(t, c, "c", l)
+	if !has(c, "a") || has(c, "b") || !has(c, "c") {
+		t.Fatal("evicted a recently used entry")
+	}
+	if s := c.Stats(); s.Hits != 1 || s.Misses != 3 || s.Evictions != 1 || s.Entries != 2 {
+		t.Fatalf("stats %+v", s)
+	}
+	if top := c.TopKeys(1); len(top) != 1 || top[0].Key != "a" || top[0].Hits != 1 {
+		t.Fatalf("top keys %+v", top)
+	}
+}
+
+func TestByteLimit(t *testing.T) {
+	c := New[string, string](Options[string]{MaxBytes: 10})
+	for _, key := range []string{"a", "b", "c"} {
+		c.set(key, "1234", nil, nil) // 4 bytes each
+	}
+	if has(c, "a") || !has(c, "b") || !has(c, "c") {
+		t.Fatal("the oldest entry wasn't evicted")
+	}
+	if s := c.Stats(); s.Bytes != 8 || s.Evictions != 1 {
+		t.Fatalf("stats %+v", s)
+	}
+
+	// Replacing an entry accounts for its new size
+	c.set("b", "123456", nil, nil)
+	if s := c.Stats(); s.Bytes != 10 || s.Entries != 2 {
+		t.Fatalf("after replacing: stats %+v", s)
+	}
+
+	// A value larger than the cache isn't stored and evicts nothing
+	if c.set("huge", "12345678901", nil, nil) {
+		t.Fatal("stored a value over MaxBytes")
+	}
+	if s := c.Stats(); s.Entries != 2 || s.Bytes != 10 {
+		t.Fatalf("after a huge value: stats %+v", s)
+	}
+}
+
+func TestSizeOf(t *testing.T) {
+	tests := []struct {
+		value any
+		want  int64
+	}{
+		{"abc", 3},
+		{[]byte("abcd"), 4},
+		{sized(100), 100},
+		{[]sized{10, 20}, 30 + defaultEntrySize},
+		{42, defaultEntrySize},
+	}
+	for _, tt := range tests {
+		if got := sizeOf(tt.value); got != tt.want {
+			t.Errorf("sizeOf(%#v) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+type sized int64
+
+func (s sized) Size() int64 { return int64(s) }
+
+func TestTTL(t *testing.T) {
+	c := New[string, string](Options[string]{TTL: time.Hour})
+	l := &counter{}
+	get(t, c, "a", l)
+	if v := get(t, c, "a", l); v != "a v1" {
+		t.Fatalf("within the TTL: got %q", v)
+	}
+
+	expire(c, "a", time.Second)
+	if v := get(t, c, "a", l); v != "a v2" {
+		t.Fatalf("after the TTL: got %q", v)
+	}
+
+	// Without a TTL entries never expire
+	forever := New[string, string](Options[string]{})
+	get(t, forever, "a", l)
+	if !forever.items["a"].Value.(*cacheEntry[string, string]).expiresAt.IsZero() {
+		t.Fatal("an entry without TTL has an expiry")
+	}
+}
+
+// expire moves the expiry of key to ago in the past
+func expire(c *Cache[string, string], key string, ago time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[key].Value.(*cacheEntry[string, string]).expiresAt = time.Now().Add(-ago)
+}
+
+// A load started before an invalidation doesn't store what it read
+func TestInvalidationDiscardsStaleLoads(t *testing.T) {
+	for name, invalidate := range map[string]func(c *Cache[string, string]){
+		"key":   func(c *Cache[string, string]) { c.Invalidate("a") },
+		"tag":   func(c *Cache[string, string]) { c.InvalidateTag("notes") },
+		"clear": func(c *Cache[string, string]) { c.Clear() },
+		"other": func(c *Cache[string, string]) { c.Invalidate("b") },
+	} {
+		c := New[string, string](Options[string]{})
+		started, release := make(chan struct{}), make(chan struct{})
+		done := make(chan string)
+		go func() {
+			v, _ := c.GetOrLoad("a", func() (string, []string, error) {
+				close(started)
+				<-release
+				return "old", []string{"notes"}, nil
+			})
+			done <- v
+		}()
+
+		<-started
+		invalidate(c)
+		close(release)
+		if v := <-done; v != "old" {
+			t.Fatalf("%s: the caller got %q", name, v)
+		}
+		waitIdle(c)
+		if has(c, "a") {
+			t.Errorf("%s: a load from before the invalidation was cached", name)
+		}
+	}
+}
+
+// waitIdle waits for background loads to finish
+func waitIdle(c *Cache[string, string]) {
+	for {
+		c.mu.Lock()
+		n := len(c.calls)
+		c.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestInvalidateTag(t *testing.T) {
+	c := New[string, string](Options[string]{})
+	c.set("note:1", "1", []string{"note:1", "category:1"}, nil)
+	c.set("note:2", "2", []string{"note:2", "category:1"}, nil)
+	c.set("note:3", "3", []string{"note:3", "category:2"}, nil)
+	c.set("categories", "all", nil, nil)
+
+	if n := c.InvalidateTag("category:1"); n != 2 {
+		t.Fatalf("removed %d entries, want 2", n)
+	}
+	if has(c, "note:1") || has(c, "note:2") || !has(c, "note:3") || !has(c, "categories") {
+		t.Fatal("wrong entries removed")
+	}
+	if n := c.InvalidateTag("category:1"); n != 0 {
+		t.Fatalf("second invalidation removed %d entries", n)
+	}
+
+	// Removed entries leave no tags behind, a replaced entry keeps only its new tags
+	c.set("note:3", "3", []string{"note:3"}, nil)
+	if n := c.InvalidateTag("category:2"); n != 0 || !has(c, "note:3") {
+		t.Fatalf("a replaced entry kept its old tag")
+	}
+	c.mu.Lock()
+	tags := len(c.tags)
+	c.mu.Unlock()
+	if tags != 1 {
+		t.Fatalf("%d tags left, want 1", tags)
+	}
+}
+
+// Concurrent misses of one key share a single load
+func TestGetOrLoadSharesLoads(t *testing.T) {
+	const callers = 20
+	c := New[string, string](Options[string]{})
+	var loads atomic.Int32
+	release := make(chan struct{})
+	load := func() (string, []string, error) {
+		loads.Add(1)
+		<-release
+		return "value", nil, nil
+	}
+
+	var wg sync.WaitGroup
+	values := make(chan string, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.GetOrLoad("a", load)
+			if err != nil {
+				t.Error(err)
+			}
+			values <- v
+		}()
+	}
+	// Every caller has missed and joined the load before it returns
+	for c.Stats().Misses < callers {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(values)
+
+	if n := loads.Load(); n != 1 {
+		t.Fatalf("%d loads for %d concurrent callers, want 1", n, callers)
+	}
+	for v := range values {
+		if v != "value" {
+			t.Fatalf("a caller got %q", v)
+		}
+	}
+	if !has(c, "a") {
+		t.Fatal("the shared load wasn't cached")
+	}
+}
+
+// The error of a load reaches the caller and isn't cached
+func TestGetOrLoadError(t *testing.T) {
+	c := New[string, string](Options[string]{})
+	failed := errors.New("database is down")
+	if _, err := c.GetOrLoad("a", func() (string, []string, error) { return "", nil, failed }); !errors.Is(err, failed) {
+		t.Fatalf("got %v, want the error of the loader", err)
+	}
+	l := &counter{}
+	if v := get(t, c, "a", l); v != "a v1" {
+		t.Fatalf("after an error: got %q", v)
+	}
+}
+
+func TestGetOrLoadStale(t *testing.T) {
+	c := New[string, string](Options[string]{TTL: time.Hour, StaleTTL: time.Minute})
+	l := &counter{}
+	get(t, c, "a", l)
+
+	// An expired entry within StaleTTL is served while one reload runs in the background
+	expire(c, "a", time.Second)
+	started, release := make(chan struct{}), make(chan struct{})
+	var reloads atomic.Int32
+	reload := func() (string, []string, error) {
+		if reloads.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return "a v2", nil, nil
+	}
+	for i := 0; i < 3; i++ {
+		v, err := c.GetOrLoadStale("a", reload)
+		if err != nil || v != "a v1" {
+			t.Fatalf("stale read %d: got %q, %v", i+1, v, err)
+		}
+	}
+	<-started
+	close(release)
+	waitIdle(c)
+	if n := reloads.Load(); n != 1 {
+		t.Fatalf("%d background reloads, want 1", n)
+	}
+	if v, _ := c.GetOrLoadStale("a", l.loader("a")); v != "a v2" {
+		t.Fatalf("after the reload: got %q", v)
+	}
+
+	// GetOrLoad doesn't serve stale entries
+	expire(c, "a", time.Second)
+	if v := get(t, c, "a", l); v != "a v2" {
+		t.Fatalf("GetOrLoad of an expired entry: got %q, want a fresh load", v)
+	}
+
+	// Past StaleTTL the caller waits for the load
+	expire(c, "a", 2*time.Minute)
+	if v, _ := c.GetOrLoadStale("a", l.loader("a")); v != "a v3" {
+		t.Fatalf("past StaleTTL: got %q", v)
+	}
+}
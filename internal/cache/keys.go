@@ -0,0 +1,30 @@
+package cache
+
+import "fmt"
+
+// Keys and tags shared by everything that caches notes. Entries are tagged
+// with the note and category they were built from, so a write can evict
+// exactly what depends on it.
+
+const CategoriesKey = "categories"
+
+func NoteKey(id int64) string {
+	return fmt.Sprintf("note:%d", id)
+}
+
+func NotesKey(categoryID int64) string {
+	return fmt.Sprintf("notes:%d", categoryID)
+}
+
+// SSRKey is the key for a server-rendered note page
+func SSRKey(id int64) string {
+	return fmt.Sprintf("ssr:%d", id)
+}
+
+func NoteTag(id int64) string {
+	return fmt.Sprintf("tag:note:%d", id)
+}
+
+func CategoryTag(categoryID int64) string {
+	return fmt.Sprintf("tag:category:%d", categoryID)
+}
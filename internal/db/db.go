@@ -2,41 +2,170 @@ package db
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
+	"os"
+	"runtime"
 	"strings"
 	"time"
 
-	_ "github.com/glebarez/go-sqlite"
+	sqlite "github.com/glebarez/go-sqlite"
 	"lava-notes/internal/models"
 )
 
 type DB struct {
-	conn *sql.DB
+	conn  *sql.DB // single connection, SQLite allows one writer at a time
+	read  *sql.DB // pool of read-only connections, served concurrently in WAL mode
+	stmts statements
 }
 
+// statements are prepared once for the hottest read queries
+type statements struct {
+	getCategories *sql.Stmt
+	getCategory   *sql.Stmt
+	getNotes      *sql.Stmt
+	getNote       *sql.Stmt
+}
+
+// busyTimeout is how long a connection waits for a lock before failing with SQLITE_BUSY
+const busyTimeout = 5 * time.Second
+
 func New(path string) (*DB, error) {
-	conn, err := sql.Open("sqlite", path)
+	// Pragmas are applied by the driver to every new connection of a pool
+	conn, err := sql.Open("sqlite", dsn(path,
+		"journal_mode(WAL)",
+		"synchronous(NORMAL)",
+		"foreign_keys(1)",
+		fmt.Sprintf("busy_timeout(%d)", busyTimeout.Milliseconds()),
+	))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	// Writers queue in database/sql instead of failing on the file lock
+	conn.SetMaxOpenConns(1)
+	conn.SetMaxIdleConns(1)
+	conn.SetConnMaxLifetime(0)
 
 	if err := conn.Ping(); err != nil {
+		conn.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	// Enable foreign key enforcement for CASCADE deletes
-	if _, err := conn.Exec("PRAGMA foreign_keys = ON"); err != nil {
-		return nil, fmt.Errorf("failed to enable foreign keys: %w", err)
-	}
-
 	db := &DB{conn: conn}
 	if err := db.migrate(); err != nil {
+		conn.Close()
 		return nil, fmt.Errorf("failed to migrate: %w", err)
 	}
 
+	// Opened after migrating, so readers never see a partial schema
+	read, err := sql.Open("sqlite", dsn(path,
+		"query_only(1)",
+		"foreign_keys(1)",
+		fmt.Sprintf("busy_timeout(%d)", busyTimeout.Milliseconds()),
+	))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	readers := runtime.NumCPU()
+	if readers < 4 {
+		readers = 4
+	}
+	read.SetMaxOpenConns(readers)
+	read.SetMaxIdleConns(readers)
+	db.read = read
+
+	if err := db.prepare(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare statements: %w", err)
+	}
+
 	return db, nil
 }
 
+// dsn adds per-connection pragmas to a database path
+func dsn(path string, pragmas ...string) string {
+	q := url.Values{}
+	for _, p := range pragmas {
+		q.Add("_pragma", p)
+	}
+	return path + "?" + q.Encode()
+}
+
+func (d *DB) prepare() error {
+	var err error
+	prepare := func(query string) *sql.Stmt {
+		if err != nil {
+			return nil
+		}
+		var stmt *sql.Stmt
+		stmt, err = d.read.Prepare(query)
+		return stmt
+	}
+	d.stmts = statements{
+		getCategories: prepare(`SELECT id, name, icon, created_at, updated_at FROM categories ORDER BY name`),
+		getCategory:   prepare(`SELECT id, name, icon, created_at, updated_at FROM categories WHERE id = ?`),
+		getNotes:      prepare(`SELECT id, category_id, name, icon, created_at, updated_at FROM notes WHERE category_id = ? ORDER BY name`),
+		getNote:       prepare(`SELECT id, category_id, name, content, icon, created_at, updated_at FROM notes WHERE id = ?`),
+	}
+	return err
+}
+
+// Backup writes a consistent copy of the live database to path, which must not exist
+func (d *DB) Backup(path string) error {
+	_, err := d.conn.Exec(`VACUUM INTO ?`, path)
+	return err
+}
+
+// VerifyFile runs an integrity check on a database file without migrating it
+func VerifyFile(path string) error {
+	// Opening a missing file would create an empty database
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	rows, err := conn.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return err
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
+
+	// An empty or unrelated SQLite file passes the check but isn't a Lava database
+	var n int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('categories', 'notes')`).Scan(&n); err != nil {
+		return err
+	}
+	if n != 2 {
+		return fmt.Errorf("not a Lava Notes database")
+	}
+	return nil
+}
+
 func (d *DB) migrate() error {
 	queries := []string{
 		`CREATE TABLE IF NOT EXISTS categories (
@@ -68,6 +197,44 @@ func (d *DB) migrate() error {
 			note_id INTEGER PRIMARY KEY,
 			count INTEGER DEFAULT 0
 		)`,
+		`CREATE TABLE IF NOT EXISTS view_daily (
+			note_id INTEGER NOT NULL,
+			day TEXT NOT NULL,
+			count INTEGER DEFAULT 0,
+			PRIMARY KEY (note_id, day)
+		)`,
+		`CREATE TABLE IF NOT EXISTS view_sources (
+			note_id INTEGER NOT NULL,
+			day TEXT NOT NULL,
+			referrer TEXT NOT NULL DEFAULT '',
+			utm_source TEXT NOT NULL DEFAULT '',
+			utm_medium TEXT NOT NULL DEFAULT '',
+			utm_campaign TEXT NOT NULL DEFAULT '',
+			count INTEGER DEFAULT 0,
+			PRIMARY KEY (note_id, day, referrer, utm_source, utm_medium, utm_campaign)
+		)`,
+		`CREATE TABLE IF NOT EXISTS totp (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			secret TEXT NOT NULL,
+			enabled BOOLEAN DEFAULT FALSE,
+			last_step INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS webauthn_credentials (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			credential_id TEXT NOT NULL UNIQUE,
+			public_key BLOB NOT NULL,
+			sign_count INTEGER DEFAULT 0,
+			name TEXT DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS recovery_codes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			code_hash TEXT NOT NULL UNIQUE,
+			used BOOLEAN DEFAULT FALSE,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
 	}
 
 	for _, q := range queries {
@@ -75,16 +242,66 @@ func (d *DB) migrate() error {
 			return fmt.Errorf("failed to execute query: %w", err)
 		}
 	}
+
+	// Columns added after the initial schema
+	columns := []struct{ table, column, def string }{
+		{"auth_tokens", "max_uses", "INTEGER DEFAULT 1"},
+		{"auth_tokens", "uses", "INTEGER DEFAULT 0"},
+		{"auth_tokens", "revoked", "BOOLEAN DEFAULT FALSE"},
+		{"views", "bot_count", "INTEGER DEFAULT 0"},
+		{"view_daily", "bots", "INTEGER DEFAULT 0"},
+	}
+	for _, c := range columns {
+		if err := d.addColumn(c.table, c.column, c.def); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", c.table, c.column, err)
+		}
+	}
 	return nil
 }
 
+// addColumn adds a column unless the table already has it
+func (d *DB) addColumn(table, column, def string) error {
+	rows, err := d.conn.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = d.conn.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, def))
+	return err
+}
+
 func (d *DB) Close() error {
+	for _, stmt := range []*sql.Stmt{d.stmts.getCategories, d.stmts.getCategory, d.stmts.getNotes, d.stmts.getNote} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+	if d.read != nil {
+		d.read.Close()
+	}
 	return d.conn.Close()
 }
 
 // Categories
 func (d *DB) GetCategories() ([]models.Category, error) {
-	rows, err := d.conn.Query(`SELECT id, name, icon, created_at, updated_at FROM categories ORDER BY name`)
+	rows, err := d.stmts.getCategories.Query()
 	if err != nil {
 		return nil, err
 	}
@@ -103,7 +320,7 @@ func (d *DB) GetCategories() ([]models.Category, error) {
 
 func (d *DB) GetCategory(id int64) (*models.Category, error) {
 	var c models.Category
-	err := d.conn.QueryRow(`SELECT id, name, icon, created_at, updated_at FROM categories WHERE id = ?`, id).
+	err := d.stmts.getCategory.QueryRow(id).
 		Scan(&c.ID, &c.Name, &c.Icon, &c.CreatedAt, &c.UpdatedAt)
 	if err != nil {
 		return nil, err
@@ -113,7 +330,7 @@ func (d *DB) GetCategory(id int64) (*models.Category, error) {
 
 func (d *DB) GetCategoryByName(name string) (*models.Category, error) {
 	var c models.Category
-	err := d.conn.QueryRow(`SELECT id, name, icon, created_at, updated_at FROM categories WHERE name = ?`, name).
+	err := d.read.QueryRow(`SELECT id, name, icon, created_at, updated_at FROM categories WHERE name = ?`, name).
 		Scan(&c.ID, &c.Name, &c.Icon, &c.CreatedAt, &c.UpdatedAt)
 	if err != nil {
 		return nil, err
@@ -122,21 +339,31 @@ func (d *DB) GetCategoryByName(name string) (*models.Category, error) {
 }
 
 func (d *DB) CreateCategory(name, icon string) (*models.Category, error) {
+	id, err := createCategory(d.conn, name, icon)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetCategory(id)
+}
+
+func createCategory(e execer, name, icon string) (int64, error) {
 	if icon == "" {
 		icon = "folder"
 	}
-	result, err := d.conn.Exec(`INSERT INTO categories (name, icon) VALUES (?, ?)`, name, icon)
+	result, err := e.Exec(`INSERT INTO categories (name, icon) VALUES (?, ?)`, name, icon)
 	if err != nil {
-		return nil, err
+		return 0, conflictError(err)
 	}
-	id, _ := result.LastInsertId()
-	return d.GetCategory(id)
+	return result.LastInsertId()
 }
 
 func (d *DB) UpdateCategory(id int64, name, icon string) (*models.Category, error) {
+	if icon == "" {
+		icon = "folder"
+	}
 	_, err := d.conn.Exec(`UPDATE categories SET name = ?, icon = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, name, icon, id)
 	if err != nil {
-		return nil, err
+		return nil, conflictError(err)
 	}
 	return d.GetCategory(id)
 }
@@ -148,7 +375,7 @@ func (d *DB) DeleteCategory(id int64) error {
 
 // Notes
 func (d *DB) GetNotes(categoryID int64) ([]models.NoteListItem, error) {
-	rows, err := d.conn.Query(`SELECT id, category_id, name, icon, updated_at FROM notes WHERE category_id = ? ORDER BY name`, categoryID)
+	rows, err := d.stmts.getNotes.Query(categoryID)
 	if err != nil {
 		return nil, err
 	}
@@ -157,7 +384,179 @@ func (d *DB) GetNotes(categoryID int64) ([]models.NoteListItem, error) {
 	var notes []models.NoteListItem
 	for rows.Next() {
 		var n models.NoteListItem
-		if err := rows.Scan(&n.ID, &n.CategoryID, &n.Name, &n.Icon, &n.UpdatedAt); err != nil {
+		if err := rows.Scan(&n.ID, &n.CategoryID, &n.Name, &n.Icon, &n.CreatedAt, &n.UpdatedAt); err != nil {
+			return nil, err
+		}
+		notes = append(notes, n)
+	}
+	return notes, nil
+}
+
+// ErrConflict is returned when a name is already taken, by a category or by a note of the same category
+var ErrConflict = errors.New("name already exists")
+
+// sqliteConstraintUnique is the extended result code of UNIQUE and PRIMARY KEY violations
+const sqliteConstraintUnique = 2067
+
+// conflictError maps unique constraint violations to ErrConflict
+func conflictError(err error) error {
+	var se *sqlite.Error
+	if errors.As(err, &se) && se.Code() == sqliteConstraintUnique {
+		return ErrConflict
+	}
+	return err
+}
+
+// ErrInvalidCursor is returned for cursors that are malformed or belong to another sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// noteSortKeys map sort names to expressions, timestamps compare as julian days
+// so values written by SQLite and by Go sort the same way
+var noteSortKeys = map[string]string{
+	"name":    `n.name`,
+	"created": `julianday(n.created_at)`,
+	"updated": `julianday(n.updated_at)`,
+	"views":   `COALESCE(v.count, 0)`,
+}
+
+// noteCursor points after the last note of a page
+type noteCursor struct {
+	Sort string      `json:"s"`
+	Desc bool        `json:"d,omitempty"`
+	Key  interface{} `json:"k"`
+	ID   int64       `json:"i"`
+}
+
+func (c noteCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeNoteCursor(s string) (noteCursor, error) {
+	var c noteCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	switch c.Key.(type) {
+	case string, float64:
+	default:
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// QueryNotes returns a page of notes and the cursor of the next page, empty on the last one
+func (d *DB) QueryNotes(q models.NoteQuery) ([]models.NoteListItem, string, error) {
+	if q.Sort == "" {
+		q.Sort = "name"
+	}
+	sortKey, ok := noteSortKeys[q.Sort]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown sort %q", q.Sort)
+	}
+
+	var where []string
+	var args []interface{}
+	if q.CategoryID != 0 {
+		where = append(where, `n.category_id = ?`)
+		args = append(args, q.CategoryID)
+	}
+	if q.Public {
+		where = append(where, `n.icon != 'lock' AND c.icon != 'lock'`)
+	}
+	if q.Icon != "" {
+		where = append(where, `n.icon = ?`)
+		args = append(args, q.Icon)
+	}
+	if q.Language != "" {
+		lang := strings.ToLower(q.Language)
+		cond := `(lower(n.name) GLOB ? OR lower(n.name) GLOB ?)`
+		if lang == "en" {
+			// Notes without a language suffix are English
+			cond = `(` + cond + ` OR NOT (lower(n.name) GLOB '*__[a-z][a-z]' OR lower(n.name) GLOB '*__[a-z][a-z].md'))`
+		}
+		where = append(where, cond)
+		args = append(args, "*__"+lang, "*__"+lang+".md")
+	}
+	if !q.UpdatedSince.IsZero() {
+		where = append(where, `julianday(n.updated_at) >= julianday(?)`)
+		args = append(args, q.UpdatedSince.UTC().Format(time.DateTime))
+	}
+
+	cmp, dir := ">", "ASC"
+	if q.Desc {
+		cmp, dir = "<", "DESC"
+	}
+	if q.Cursor != "" {
+		c, err := decodeNoteCursor(q.Cursor)
+		if err != nil || c.Sort != q.Sort || c.Desc != q.Desc {
+			return nil, "", ErrInvalidCursor
+		}
+		where = append(where, fmt.Sprintf(`(%[1]s %[2]s ? OR (%[1]s = ? AND n.id %[2]s ?))`, sortKey, cmp))
+		args = append(args, c.Key, c.Key, c.ID)
+	}
+
+	query := `SELECT n.id, n.category_id, n.name, n.icon, n.created_at, n.updated_at, ` + sortKey + `
+		FROM notes n JOIN categories c ON c.id = n.category_id LEFT JOIN views v ON v.note_id = n.id`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	query += fmt.Sprintf(` ORDER BY %s %s, n.id %s`, sortKey, dir, dir)
+	if q.Limit > 0 {
+		// One extra row tells whether there is a next page
+		query += ` LIMIT ?`
+		args = append(args, q.Limit+1)
+	}
+
+	rows, err := d.read.Query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	notes := []models.NoteListItem{}
+	var keys []interface{}
+	for rows.Next() {
+		var n models.NoteListItem
+		var key interface{}
+		if err := rows.Scan(&n.ID, &n.CategoryID, &n.Name, &n.Icon, &n.CreatedAt, &n.UpdatedAt, &key); err != nil {
+			return nil, "", err
+		}
+		notes = append(notes, n)
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if q.Limit <= 0 || len(notes) <= q.Limit {
+		return notes, "", nil
+	}
+	notes = notes[:q.Limit]
+	last := notes[q.Limit-1]
+	key := keys[q.Limit-1]
+	if n, ok := key.(int64); ok {
+		key = float64(n)
+	}
+	return notes, noteCursor{Sort: q.Sort, Desc: q.Desc, Key: key, ID: last.ID}.encode(), nil
+}
+
+// GetAllNotes returns every note with its content, ordered by category and name
+func (d *DB) GetAllNotes() ([]models.Note, error) {
+	rows, err := d.read.Query(`SELECT id, category_id, name, content, icon, created_at, updated_at FROM notes ORDER BY category_id, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notes []models.Note
+	for rows.Next() {
+		var n models.Note
+		if err := rows.Scan(&n.ID, &n.CategoryID, &n.Name, &n.Content, &n.Icon, &n.CreatedAt, &n.UpdatedAt); err != nil {
 			return nil, err
 		}
 		notes = append(notes, n)
@@ -167,7 +566,7 @@ func (d *DB) GetNotes(categoryID int64) ([]models.NoteListItem, error) {
 
 func (d *DB) GetNote(id int64) (*models.Note, error) {
 	var n models.Note
-	err := d.conn.QueryRow(`SELECT id, category_id, name, content, icon, created_at, updated_at FROM notes WHERE id = ?`, id).
+	err := d.stmts.getNote.QueryRow(id).
 		Scan(&n.ID, &n.CategoryID, &n.Name, &n.Content, &n.Icon, &n.CreatedAt, &n.UpdatedAt)
 	if err != nil {
 		return nil, err
@@ -177,7 +576,7 @@ func (d *DB) GetNote(id int64) (*models.Note, error) {
 
 func (d *DB) GetNoteByName(categoryID int64, name string) (*models.Note, error) {
 	var n models.Note
-	err := d.conn.QueryRow(`SELECT id, category_id, name, content, icon, created_at, updated_at FROM notes WHERE category_id = ? AND name = ?`, categoryID, name).
+	err := d.read.QueryRow(`SELECT id, category_id, name, content, icon, created_at, updated_at FROM notes WHERE category_id = ? AND name = ?`, categoryID, name).
 		Scan(&n.ID, &n.CategoryID, &n.Name, &n.Content, &n.Icon, &n.CreatedAt, &n.UpdatedAt)
 	if err != nil {
 		return nil, err
@@ -186,92 +585,500 @@ func (d *DB) GetNoteByName(categoryID int64, name string) (*models.Note, error)
 }
 
 func (d *DB) CreateNote(categoryID int64, name, content, icon string) (*models.Note, error) {
-	if icon == "" {
-		icon = "file-text"
-	}
-	result, err := d.conn.Exec(`INSERT INTO notes (category_id, name, content, icon) VALUES (?, ?, ?, ?)`, categoryID, name, content, icon)
+	id, err := createNote(d.conn, categoryID, name, content, icon)
 	if err != nil {
 		return nil, err
 	}
-	id, _ := result.LastInsertId()
 	return d.GetNote(id)
 }
 
-func (d *DB) UpdateNote(id int64, name, content, icon string) (*models.Note, error) {
-	_, err := d.conn.Exec(`UPDATE notes SET name = ?, content = ?, icon = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, name, content, icon, id)
+func createNote(e execer, categoryID int64, name, content, icon string) (int64, error) {
+	if icon == "" {
+		icon = "file-text"
+	}
+	result, err := e.Exec(`INSERT INTO notes (category_id, name, content, icon) VALUES (?, ?, ?, ?)`, categoryID, name, content, icon)
 	if err != nil {
+		return 0, conflictError(err)
+	}
+	return result.LastInsertId()
+}
+
+func (d *DB) UpdateNote(id, categoryID int64, name, content, icon string) (*models.Note, error) {
+	if err := updateNote(d.conn, id, categoryID, name, content, icon); err != nil {
 		return nil, err
 	}
 	return d.GetNote(id)
 }
 
+func updateNote(e execer, id, categoryID int64, name, content, icon string) error {
+	if icon == "" {
+		icon = "file-text"
+	}
+	_, err := e.Exec(`UPDATE notes SET category_id = ?, name = ?, content = ?, icon = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, categoryID, name, content, icon, id)
+	return conflictError(err)
+}
+
+// SetNoteTimestamps overrides the creation and update times of a note, zero times are left unchanged
+func (d *DB) SetNoteTimestamps(id int64, createdAt, updatedAt time.Time) error {
+	return setNoteTimestamps(d.conn, id, createdAt, updatedAt)
+}
+
+func setNoteTimestamps(e execer, id int64, createdAt, updatedAt time.Time) error {
+	if !createdAt.IsZero() {
+		if _, err := e.Exec(`UPDATE notes SET created_at = ? WHERE id = ?`, createdAt.UTC().Format(time.DateTime), id); err != nil {
+			return err
+		}
+	}
+	if !updatedAt.IsZero() {
+		if _, err := e.Exec(`UPDATE notes SET updated_at = ? WHERE id = ?`, updatedAt.UTC().Format(time.DateTime), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (d *DB) DeleteNote(id int64) error {
 	_, err := d.conn.Exec(`DELETE FROM notes WHERE id = ?`, id)
 	return err
 }
 
 // Auth Tokens
-func (d *DB) CreateAuthToken(token string, expiresAt time.Time) error {
-	_, err := d.conn.Exec(`INSERT INTO auth_tokens (token, expires_at) VALUES (?, ?)`, token, expiresAt)
+const authTokenColumns = `id, token, used, max_uses, uses, revoked, created_at, expires_at`
+
+func scanAuthToken(row interface{ Scan(...interface{}) error }) (*models.AuthToken, error) {
+	var t models.AuthToken
+	if err := row.Scan(&t.ID, &t.Token, &t.Used, &t.MaxUses, &t.Uses, &t.Revoked, &t.CreatedAt, &t.ExpiresAt); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (d *DB) CreateAuthToken(token string, expiresAt time.Time, maxUses int) error {
+	_, err := d.conn.Exec(`INSERT INTO auth_tokens (token, expires_at, max_uses) VALUES (?, ?, ?)`, token, expiresAt, maxUses)
 	return err
 }
 
 func (d *DB) GetAuthToken(token string) (*models.AuthToken, error) {
-	var t models.AuthToken
-	err := d.conn.QueryRow(`SELECT id, token, used, created_at, expires_at FROM auth_tokens WHERE token = ?`, token).
-		Scan(&t.ID, &t.Token, &t.Used, &t.CreatedAt, &t.ExpiresAt)
+	return scanAuthToken(d.read.QueryRow(`SELECT `+authTokenColumns+` FROM auth_tokens WHERE token = ?`, token))
+}
+
+func (d *DB) GetAuthTokens() ([]models.AuthToken, error) {
+	rows, err := d.read.Query(`SELECT ` + authTokenColumns + ` FROM auth_tokens ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.AuthToken
+	for rows.Next() {
+		t, err := scanAuthToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, nil
+}
+
+// ConsumeAuthToken atomically counts one use of a token.
+// Returns false if the token is revoked or has no uses left.
+func (d *DB) ConsumeAuthToken(token string) (bool, error) {
+	result, err := d.conn.Exec(`UPDATE auth_tokens SET uses = uses + 1, used = (uses + 1 >= max_uses)
+		WHERE token = ? AND uses < max_uses AND revoked = FALSE`, token)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n == 1, nil
+}
+
+func (d *DB) RevokeAuthToken(id int64) (bool, error) {
+	result, err := d.conn.Exec(`UPDATE auth_tokens SET revoked = TRUE WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n == 1, nil
+}
+
+func (d *DB) DeleteAuthTokens(ids []int64) error {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		if _, err := tx.Exec(`DELETE FROM auth_tokens WHERE id = ?`, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// TOTP
+func (d *DB) GetTOTP() (*models.TOTP, error) {
+	var t models.TOTP
+	err := d.read.QueryRow(`SELECT secret, enabled, last_step, created_at FROM totp WHERE id = 1`).
+		Scan(&t.Secret, &t.Enabled, &t.LastStep, &t.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &t, nil
 }
 
-func (d *DB) MarkTokenUsed(token string) error {
-	_, err := d.conn.Exec(`UPDATE auth_tokens SET used = TRUE WHERE token = ?`, token)
+// SaveTOTPSecret stores a pending (not yet enabled) secret, replacing any previous one
+func (d *DB) SaveTOTPSecret(secret string) error {
+	_, err := d.conn.Exec(`INSERT OR REPLACE INTO totp (id, secret, enabled, last_step) VALUES (1, ?, FALSE, 0)`, secret)
 	return err
 }
 
-// Views
-func (d *DB) GetAllViews() (map[int64]int64, error) {
-	rows, err := d.conn.Query(`SELECT note_id, count FROM views`)
+// EnableTOTP enables the stored secret and replaces recovery codes in one transaction
+func (d *DB) EnableTOTP(codeHashes []string) error {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE totp SET enabled = TRUE WHERE id = 1`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes`); err != nil {
+		return err
+	}
+	for _, h := range codeHashes {
+		if _, err := tx.Exec(`INSERT INTO recovery_codes (code_hash) VALUES (?)`, h); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// AdvanceTOTPStep records the last accepted time step, returns false if step was already used
+func (d *DB) AdvanceTOTPStep(step int64) (bool, error) {
+	result, err := d.conn.Exec(`UPDATE totp SET last_step = ? WHERE id = 1 AND last_step < ?`, step, step)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n == 1, nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used, returns false if none matched
+func (d *DB) UseRecoveryCode(codeHash string) (bool, error) {
+	result, err := d.conn.Exec(`UPDATE recovery_codes SET used = TRUE WHERE code_hash = ? AND used = FALSE`, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n == 1, nil
+}
+
+func (d *DB) CountRecoveryCodes() (int, error) {
+	var n int
+	err := d.read.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE used = FALSE`).Scan(&n)
+	return n, err
+}
+
+// DeleteTOTP removes the secret and all recovery codes
+func (d *DB) DeleteTOTP() error {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM totp`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// WebAuthn credentials
+func (d *DB) GetWebAuthnCredentials() ([]models.WebAuthnCredential, error) {
+	rows, err := d.read.Query(`SELECT id, credential_id, public_key, sign_count, name, created_at, last_used_at FROM webauthn_credentials ORDER BY created_at`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	views := make(map[int64]int64)
+	var creds []models.WebAuthnCredential
 	for rows.Next() {
-		var noteID, count int64
-		if err := rows.Scan(&noteID, &count); err != nil {
+		var c models.WebAuthnCredential
+		if err := rows.Scan(&c.ID, &c.CredentialID, &c.PublicKey, &c.SignCount, &c.Name, &c.CreatedAt, &c.LastUsedAt); err != nil {
 			return nil, err
 		}
+		creds = append(creds, c)
+	}
+	return creds, nil
+}
+
+func (d *DB) GetWebAuthnCredential(credentialID string) (*models.WebAuthnCredential, error) {
+	var c models.WebAuthnCredential
+	err := d.read.QueryRow(`SELECT id, credential_id, public_key, sign_count, name, created_at, last_used_at FROM webauthn_credentials WHERE credential_id = ?`, credentialID).
+		Scan(&c.ID, &c.CredentialID, &c.PublicKey, &c.SignCount, &c.Name, &c.CreatedAt, &c.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (d *DB) CreateWebAuthnCredential(credentialID string, publicKey []byte, signCount uint32, name string) error {
+	_, err := d.conn.Exec(`INSERT INTO webauthn_credentials (credential_id, public_key, sign_count, name) VALUES (?, ?, ?, ?)`, credentialID, publicKey, signCount, name)
+	return err
+}
+
+func (d *DB) UpdateWebAuthnSignCount(credentialID string, signCount uint32) error {
+	_, err := d.conn.Exec(`UPDATE webauthn_credentials SET sign_count = ?, last_used_at = CURRENT_TIMESTAMP WHERE credential_id = ?`, signCount, credentialID)
+	return err
+}
+
+func (d *DB) DeleteWebAuthnCredential(id int64) error {
+	_, err := d.conn.Exec(`DELETE FROM webauthn_credentials WHERE id = ?`, id)
+	return err
+}
+
+// Views
+// GetAllViews returns human and bot view counts per note
+func (d *DB) GetAllViews() (map[int64]int64, map[int64]int64, error) {
+	rows, err := d.read.Query(`SELECT note_id, count, bot_count FROM views`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	views := make(map[int64]int64)
+	bots := make(map[int64]int64)
+	for rows.Next() {
+		var noteID, count, botCount int64
+		if err := rows.Scan(&noteID, &count, &botCount); err != nil {
+			return nil, nil, err
+		}
 		views[noteID] = count
+		bots[noteID] = botCount
 	}
-	return views, nil
+	return views, bots, nil
 }
 
-func (d *DB) SaveViews(views map[int64]int64) error {
+// GetTopViewedNoteIDs returns the most viewed notes, excluding private ones
+func (d *DB) GetTopViewedNoteIDs(limit int) ([]int64, error) {
+	rows, err := d.read.Query(`
+		SELECT v.note_id
+		FROM views v
+		JOIN notes n ON n.id = v.note_id
+		JOIN categories c ON n.category_id = c.id
+		WHERE n.icon != 'lock' AND c.icon != 'lock'
+		ORDER BY v.count DESC
+		LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ApplyViewDeltas adds view increments per note, per note and UTC day, and per source
+// to the stored counts in a single transaction, so a failed flush can be retried
+// without counting anything twice
+func (d *DB) ApplyViewDeltas(viewCounts, botCounts map[int64]int64, dailyViews, dailyBots map[int64]map[string]int64, sourceViews map[models.ViewSource]int64) error {
 	tx, err := d.conn.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO views (note_id, count) VALUES (?, ?)`)
+	views, err := tx.Prepare(`INSERT INTO views (note_id, count, bot_count) VALUES (?, ?, ?)
+		ON CONFLICT(note_id) DO UPDATE SET count = count + excluded.count, bot_count = bot_count + excluded.bot_count`)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
+	defer views.Close()
 
-	for noteID, count := range views {
-		if _, err := stmt.Exec(noteID, count); err != nil {
+	for noteID, n := range viewCounts {
+		if _, err := views.Exec(noteID, n, 0); err != nil {
+			return err
+		}
+	}
+	for noteID, n := range botCounts {
+		if _, err := views.Exec(noteID, 0, n); err != nil {
 			return err
 		}
 	}
 
+	daily, err := tx.Prepare(`INSERT INTO view_daily (note_id, day, count, bots) VALUES (?, ?, ?, ?)
+		ON CONFLICT(note_id, day) DO UPDATE SET count = count + excluded.count, bots = bots + excluded.bots`)
+	if err != nil {
+		return err
+	}
+	defer daily.Close()
+
+	for noteID, days := range dailyViews {
+		for day, n := range days {
+			if _, err := daily.Exec(noteID, day, n, 0); err != nil {
+				return err
+			}
+		}
+	}
+	for noteID, days := range dailyBots {
+		for day, n := range days {
+			if _, err := daily.Exec(noteID, day, 0, n); err != nil {
+				return err
+			}
+		}
+	}
+
+	sources, err := tx.Prepare(`INSERT INTO view_sources (note_id, day, referrer, utm_source, utm_medium, utm_campaign, count)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(note_id, day, referrer, utm_source, utm_medium, utm_campaign) DO UPDATE SET count = count + excluded.count`)
+	if err != nil {
+		return err
+	}
+	defer sources.Close()
+
+	for src, n := range sourceViews {
+		if _, err := sources.Exec(src.NoteID, src.Day, src.Referrer, src.Source, src.Medium, src.Campaign, n); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteViews removes all view data of the given notes
+func (d *DB) DeleteViews(noteIDs []int64) error {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"views", "view_daily", "view_sources"} {
+		stmt, err := tx.Prepare(fmt.Sprintf(`DELETE FROM %s WHERE note_id = ?`, table))
+		if err != nil {
+			return err
+		}
+		for _, id := range noteIDs {
+			if _, err := stmt.Exec(id); err != nil {
+				stmt.Close()
+				return err
+			}
+		}
+		stmt.Close()
+	}
+
 	return tx.Commit()
 }
 
+// DeleteOrphanViews removes view data of notes that no longer exist, returns the number of notes cleaned up
+func (d *DB) DeleteOrphanViews() (int64, error) {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var removed int64
+	for _, table := range []string{"views", "view_daily", "view_sources"} {
+		result, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE note_id NOT IN (SELECT id FROM notes)`, table))
+		if err != nil {
+			return 0, err
+		}
+		if table == "views" {
+			removed, _ = result.RowsAffected()
+		}
+	}
+
+	return removed, tx.Commit()
+}
+
+// GetSourceTotals aggregates views by referrer and campaign between two days (inclusive).
+// A noteID of 0 aggregates across all notes.
+func (d *DB) GetSourceTotals(noteID int64, from, to string) ([]models.SourceTotal, error) {
+	query := `SELECT referrer, utm_source, utm_medium, utm_campaign, SUM(count) AS total
+		FROM view_sources WHERE day >= ? AND day <= ?`
+	args := []interface{}{from, to}
+	if noteID != 0 {
+		query += ` AND note_id = ?`
+		args = append(args, noteID)
+	}
+	query += ` GROUP BY referrer, utm_source, utm_medium, utm_campaign ORDER BY total DESC`
+
+	rows, err := d.read.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []models.SourceTotal
+	for rows.Next() {
+		var t models.SourceTotal
+		if err := rows.Scan(&t.Referrer, &t.Source, &t.Medium, &t.Campaign, &t.Views); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+	return totals, nil
+}
+
+// GetNoteDailyViews returns per-day views of a note between two days (inclusive)
+func (d *DB) GetNoteDailyViews(noteID int64, from, to string) ([]models.DailyViews, error) {
+	rows, err := d.read.Query(`SELECT day, count, bots FROM view_daily WHERE note_id = ? AND day >= ? AND day <= ? ORDER BY day`, noteID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var days []models.DailyViews
+	for rows.Next() {
+		var dv models.DailyViews
+		if err := rows.Scan(&dv.Day, &dv.Views, &dv.Bots); err != nil {
+			return nil, err
+		}
+		days = append(days, dv)
+	}
+	return days, nil
+}
+
+// GetViewTotals returns views per note between two days (inclusive), most viewed first
+func (d *DB) GetViewTotals(from, to string) ([]models.NoteViewTotal, error) {
+	rows, err := d.read.Query(`
+		SELECT n.id, n.name, n.icon, n.category_id, c.name, SUM(v.count) AS total
+		FROM view_daily v
+		JOIN notes n ON n.id = v.note_id
+		JOIN categories c ON n.category_id = c.id
+		WHERE v.day >= ? AND v.day <= ?
+		GROUP BY n.id
+		HAVING total > 0
+		ORDER BY total DESC`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []models.NoteViewTotal
+	for rows.Next() {
+		var t models.NoteViewTotal
+		if err := rows.Scan(&t.NoteID, &t.Name, &t.Icon, &t.CategoryID, &t.CategoryName, &t.Views); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+	return totals, nil
+}
+
 // SearchNotes searches notes by query in title and content
 // includePrivate controls whether to include notes with lock icon
 // Encrypted notes (content starting with LAVA_ENC:) are excluded from search
@@ -294,7 +1101,7 @@ func (d *DB) SearchNotes(query string, includePrivate bool, limit int) ([]models
 	}
 	baseQuery += ` ORDER BY n.updated_at DESC LIMIT ?`
 
-	rows, err = d.conn.Query(baseQuery, q, q, limit)
+	rows, err = d.read.Query(baseQuery, q, q, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -350,8 +1157,12 @@ func extractSnippet(content, query string, maxLen int) string {
 
 // IsCategoryPrivate checks if a category has lock icon
 func (d *DB) IsCategoryPrivate(categoryID int64) (bool, error) {
+	return isCategoryPrivate(d.read, categoryID)
+}
+
+func isCategoryPrivate(e execer, categoryID int64) (bool, error) {
 	var icon string
-	err := d.conn.QueryRow(`SELECT icon FROM categories WHERE id = ?`, categoryID).Scan(&icon)
+	err := e.QueryRow(`SELECT icon FROM categories WHERE id = ?`, categoryID).Scan(&icon)
 	if err != nil {
 		return false, err
 	}
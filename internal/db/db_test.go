@@ -0,0 +1,189 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestDB(t testing.TB) *DB {
+	t.Helper()
+	d, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+// Writes go through the writer connection and reads through the read pool,
+// a write must be visible to every reader as soon as it returns
+func TestReadAfterWrite(t *testing.T) {
+	d := newTestDB(t)
+	category, err := d.CreateCategory("Go", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const workers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			errs <- readYourWrites(d, category.ID, w)
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	notes, err := d.GetNotes(category.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != workers {
+		t.Fatalf("got %d notes, want %d", len(notes), workers)
+	}
+}
+
+func readYourWrites(d *DB, categoryID int64, w int) error {
+	name := fmt.Sprintf("note %d", w)
+	note, err := d.CreateNote(categoryID, name, "v0", "")
+	if err != nil {
+		return err
+	}
+	for i := 1; i <= 20; i++ {
+		content := fmt.Sprintf("v%d", i)
+		if _, err := d.conn.Exec(`UPDATE notes SET content = ? WHERE id = ?`, content, note.ID); err != nil {
+			return err
+		}
+		// Through a prepared statement and a plain query of the read pool
+		got, err := d.GetNote(note.ID)
+		if err != nil {
+			return err
+		}
+		if got.Content != content {
+			return fmt.Errorf("%s: read %q after writing %q", name, got.Content, content)
+		}
+		byName, err := d.GetNoteByName(categoryID, name)
+		if err != nil {
+			return err
+		}
+		if byName.Content != content {
+			return fmt.Errorf("%s by name: read %q after writing %q", name, byName.Content, content)
+		}
+	}
+
+	if err := d.DeleteNote(note.ID); err != nil {
+		return err
+	}
+	if _, err := d.GetNote(note.ID); !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%s: read after delete: got %v, want sql.ErrNoRows", name, err)
+	}
+	_, err = d.CreateNote(categoryID, name, "final", "")
+	return err
+}
+
+func TestTransactionVisibleAfterCommit(t *testing.T) {
+	d := newTestDB(t)
+
+	var id int64
+	err := d.Transaction(func(tx *Tx) error {
+		var err error
+		if id, err = tx.CreateCategory("Pending", ""); err != nil {
+			return err
+		}
+		if _, err := d.GetCategory(id); !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("uncommitted category read: got %v, want sql.ErrNoRows", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := d.GetCategory(id); err != nil || c.Name != "Pending" {
+		t.Fatalf("committed category: got %+v, %v", c, err)
+	}
+
+	rollback := errors.New("rollback")
+	err = d.Transaction(func(tx *Tx) error {
+		if _, err := tx.CreateCategory("Rolled back", ""); err != nil {
+			return err
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("got %v, want the error of fn", err)
+	}
+	if _, err := d.GetCategoryByName("Rolled back"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("rolled back category: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+// BenchmarkMixedReadWrite runs 16 goroutines against one database, 4 of them updating notes
+// and the others reading notes and note lists, and reports the throughput of each
+func BenchmarkMixedReadWrite(b *testing.B) {
+	const workers, writers, notes = 16, 4, 100
+
+	d := newTestDB(b)
+	category, err := d.CreateCategory("Bench", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	ids := make([]int64, notes)
+	for i := range ids {
+		n, err := d.CreateNote(category.ID, fmt.Sprintf("note %d", i), "content", "")
+		if err != nil {
+			b.Fatal(err)
+		}
+		ids[i] = n.ID
+	}
+
+	var ops, reads, writes atomic.Int64
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	start := time.Now()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := ops.Add(1); i <= int64(b.N); i = ops.Add(1) {
+				id := ids[i%notes]
+				if w < writers {
+					if _, err := d.UpdateNote(id, category.ID, fmt.Sprintf("note %d", i%notes), fmt.Sprintf("content %d", i), ""); err != nil {
+						b.Error(err)
+						return
+					}
+					writes.Add(1)
+					continue
+				}
+				var err error
+				if i%2 == 0 {
+					_, err = d.GetNote(id)
+				} else {
+					_, err = d.GetNotes(category.ID)
+				}
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				reads.Add(1)
+			}
+		}(w)
+	}
+	wg.Wait()
+	elapsed := time.Since(start).Seconds()
+	b.ReportMetric(float64(reads.Load())/elapsed, "reads/s")
+	b.ReportMetric(float64(writes.Load())/elapsed, "writes/s")
+}
@@ -0,0 +1,180 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// HealthReport is the result of Check, ok only when no problem was found
+type HealthReport struct {
+	OK                   bool                  `json:"ok"`
+	CheckedAt            time.Time             `json:"checked_at"`
+	Integrity            []string              `json:"integrity"`
+	ForeignKeyViolations []ForeignKeyViolation `json:"foreign_key_violations"`
+	OrphanedViews        map[string]int64      `json:"orphaned_views"`       // table -> rows of deleted notes
+	DanglingAuthTokens   int64                 `json:"dangling_auth_tokens"` // revoked, used up or expired but still stored
+	Tables               map[string]int64      `json:"tables"`               // table -> row count
+	Storage              Storage               `json:"storage"`
+	Duration             string                `json:"duration"`
+}
+
+type ForeignKeyViolation struct {
+	Table  string `json:"table"`
+	RowID  int64  `json:"rowid"`
+	Parent string `json:"parent"`
+}
+
+type Storage struct {
+	JournalMode   string `json:"journal_mode"`
+	PageSize      int64  `json:"page_size"`
+	PageCount     int64  `json:"page_count"`
+	FreelistCount int64  `json:"freelist_count"`
+	SizeBytes     int64  `json:"size_bytes"`
+	FreeBytes     int64  `json:"free_bytes"` // reclaimable with --vacuum
+}
+
+// viewTables hold per-note view data that isn't removed by foreign keys
+var viewTables = []string{"views", "view_daily", "view_sources"}
+
+// Check runs integrity and consistency checks, it only reads
+func (d *DB) Check() (*HealthReport, error) {
+	start := time.Now()
+	report := &HealthReport{
+		CheckedAt:            start.UTC(),
+		Integrity:            []string{},
+		ForeignKeyViolations: []ForeignKeyViolation{},
+		OrphanedViews:        make(map[string]int64),
+		Tables:               make(map[string]int64),
+	}
+
+	rows, err := d.read.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		report.Integrity = append(report.Integrity, msg)
+	}
+	rows.Close()
+
+	rows, err = d.read.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var v ForeignKeyViolation
+		var fkid int64
+		if err := rows.Scan(&v.Table, &v.RowID, &v.Parent, &fkid); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		report.ForeignKeyViolations = append(report.ForeignKeyViolations, v)
+	}
+	rows.Close()
+
+	for _, table := range viewTables {
+		var n int64
+		query := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE note_id NOT IN (SELECT id FROM notes)`, table)
+		if err := d.read.QueryRow(query).Scan(&n); err != nil {
+			return nil, err
+		}
+		report.OrphanedViews[table] = n
+	}
+
+	err = d.read.QueryRow(`SELECT COUNT(*) FROM auth_tokens
+		WHERE revoked OR used OR uses >= max_uses OR julianday(expires_at) < julianday('now')`).Scan(&report.DanglingAuthTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	tables, err := d.tableNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		var n int64
+		if err := d.read.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM "%s"`, table)).Scan(&n); err != nil {
+			return nil, err
+		}
+		report.Tables[table] = n
+	}
+
+	if report.Storage, err = d.storage(); err != nil {
+		return nil, err
+	}
+
+	var orphaned int64
+	for _, n := range report.OrphanedViews {
+		orphaned += n
+	}
+	report.OK = len(report.Integrity) == 1 && report.Integrity[0] == "ok" &&
+		len(report.ForeignKeyViolations) == 0 && orphaned == 0
+	report.Duration = time.Since(start).Round(time.Millisecond).String()
+	return report, nil
+}
+
+func (d *DB) tableNames() ([]string, error) {
+	rows, err := d.read.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func (d *DB) storage() (Storage, error) {
+	var s Storage
+	pragmas := []struct {
+		name string
+		dest interface{}
+	}{
+		{"journal_mode", &s.JournalMode},
+		{"page_size", &s.PageSize},
+		{"page_count", &s.PageCount},
+		{"freelist_count", &s.FreelistCount},
+	}
+	for _, p := range pragmas {
+		if err := d.read.QueryRow(`PRAGMA ` + p.name).Scan(p.dest); err != nil {
+			return s, err
+		}
+	}
+	s.SizeBytes = s.PageSize * s.PageCount
+	s.FreeBytes = s.PageSize * s.FreelistCount
+	return s, nil
+}
+
+// Vacuum rebuilds the database file, reclaiming free pages. Returns storage before and after.
+func (d *DB) Vacuum() (Storage, Storage, error) {
+	before, err := d.storage()
+	if err != nil {
+		return before, before, err
+	}
+	if _, err := d.conn.Exec(`VACUUM`); err != nil {
+		return before, before, err
+	}
+	after, err := d.storage()
+	return before, after, err
+}
+
+// Optimize refreshes query planner statistics and truncates the WAL
+func (d *DB) Optimize() error {
+	for _, stmt := range []string{`PRAGMA analysis_limit = 1000`, `PRAGMA optimize`, `PRAGMA wal_checkpoint(TRUNCATE)`} {
+		if _, err := d.conn.Exec(stmt); err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package db
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"lava-notes/internal/models"
+)
+
+// testNote is a note of the pagination fixture with the values it sorts by
+type testNote struct {
+	id, categoryID   int64
+	name             string
+	created, updated time.Time
+	views            int64
+	public           bool
+}
+
+// newPagingDB fills a database with notes whose sort keys tie often, across a public,
+// a second public and a locked category. Timestamps are stored in both the format SQLite
+// writes and the one Go writes, which only compare correctly as julian days.
+func newPagingDB(t *testing.T) (*DB, []testNote) {
+	t.Helper()
+	d := newTestDB(t)
+	var notes []testNote
+
+	base := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	categories := []struct {
+		name, icon string
+	}{{"Public", "folder"}, {"Other", "book"}, {"Private", "lock"}}
+	viewCounts := make(map[int64]int64)
+	for ci, c := range categories {
+		category, err := d.CreateCategory(c.name, c.icon)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 7; i++ {
+			name := fmt.Sprintf("note %d", i%5) // the same names in every category
+			if i >= 5 {
+				name = fmt.Sprintf("note %d__de", i)
+			}
+			icon := ""
+			if ci == 0 && i == 3 {
+				icon = "lock"
+			}
+			n, err := d.CreateNote(category.ID, name, "", icon)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tn := testNote{
+				id:         n.ID,
+				categoryID: category.ID,
+				name:       name,
+				created:    base.Add(time.Duration(i%3) * time.Hour),   // ties every third note
+				updated:    base.Add(time.Duration(-ci) * time.Minute), // ties within a category
+				views:      int64(i % 4),
+				public:     c.icon != "lock" && icon != "lock",
+			}
+			// Alternate SQLite's "2006-01-02 15:04:05" with RFC 3339, which sorts differently as text
+			created := tn.created.Format(time.DateTime)
+			if i%2 == 1 {
+				created = tn.created.Format(time.RFC3339)
+			}
+			if _, err := d.conn.Exec(`UPDATE notes SET created_at = ?, updated_at = ? WHERE id = ?`,
+				created, tn.updated.Format(time.RFC3339), n.ID); err != nil {
+				t.Fatal(err)
+			}
+			if tn.views > 0 {
+				viewCounts[n.ID] = tn.views
+			}
+			notes = append(notes, tn)
+		}
+	}
+	if err := d.ApplyViewDeltas(viewCounts, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	return d, notes
+}
+
+// expectedOrder sorts fixture notes like QueryNotes, by key and then by ID
+func expectedOrder(notes []testNote, sortBy string, desc bool) []int64 {
+	sorted := append([]testNote(nil), notes...)
+	less := func(a, b testNote) int {
+		switch sortBy {
+		case "name":
+			return strings.Compare(a.name, b.name)
+		case "created":
+			return a.created.Compare(b.created)
+		case "updated":
+			return a.updated.Compare(b.updated)
+		default:
+			return int(a.views - b.views)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		c := less(sorted[i], sorted[j])
+		if c == 0 {
+			c = int(sorted[i].id - sorted[j].id)
+		}
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+	ids := make([]int64, len(sorted))
+	for i, n := range sorted {
+		ids[i] = n.id
+	}
+	return ids
+}
+
+// pageThrough collects every page of q, failing on a repeated note
+func pageThrough(t *testing.T, d *DB, q models.NoteQuery) []int64 {
+	t.Helper()
+	var ids []int64
+	seen := make(map[int64]bool)
+	for page := 0; ; page++ {
+		if page > 100 {
+			t.Fatal("pagination doesn't end")
+		}
+		notes, next, err := d.QueryNotes(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(notes) > q.Limit {
+			t.Fatalf("page of %d notes, limit %d", len(notes), q.Limit)
+		}
+		for _, n := range notes {
+			if seen[n.ID] {
+				t.Fatalf("note %d on two pages", n.ID)
+			}
+			seen[n.ID] = true
+			ids = append(ids, n.ID)
+		}
+		if next == "" {
+			return ids
+		}
+		q.Cursor = next
+	}
+}
+
+func TestQueryNotesPagination(t *testing.T) {
+	d, notes := newPagingDB(t)
+	for _, sortBy := range []string{"name", "created", "updated", "views"} {
+		for _, desc := range []bool{false, true} {
+			want := fmt.Sprint(expectedOrder(notes, sortBy, desc))
+
+			all, next, err := d.QueryNotes(models.NoteQuery{Sort: sortBy, Desc: desc})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int64
+			for _, n := range all {
+				ids = append(ids, n.ID)
+			}
+			if got := fmt.Sprint(ids); got != want || next != "" {
+				t.Fatalf("sort %s desc %v without limit:\ngot  %s\nwant %s", sortBy, desc, got, want)
+			}
+
+			for _, limit := range []int{1, 2, 5, len(notes), len(notes) + 1} {
+				got := pageThrough(t, d, models.NoteQuery{Sort: sortBy, Desc: desc, Limit: limit})
+				if fmt.Sprint(got) != want {
+					t.Errorf("sort %s desc %v limit %d:\ngot  %v\nwant %s", sortBy, desc, limit, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestQueryNotesRejectsForeignCursors(t *testing.T) {
+	d, _ := newPagingDB(t)
+	_, cursor, err := d.QueryNotes(models.NoteQuery{Sort: "created", Limit: 2})
+	if err != nil || cursor == "" {
+		t.Fatalf("first page: cursor %q, %v", cursor, err)
+	}
+	if _, _, err := d.QueryNotes(models.NoteQuery{Sort: "created", Limit: 2, Cursor: cursor}); err != nil {
+		t.Fatalf("cursor of the same listing: %v", err)
+	}
+
+	raw := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	invalid := []struct {
+		name string
+		q    models.NoteQuery
+	}{
+		{"other sort", models.NoteQuery{Sort: "updated", Cursor: cursor}},
+		{"default sort", models.NoteQuery{Cursor: cursor}},
+		{"other order", models.NoteQuery{Sort: "created", Desc: true, Cursor: cursor}},
+		{"not base64", models.NoteQuery{Sort: "created", Cursor: "not a cursor!"}},
+		{"not JSON", models.NoteQuery{Sort: "created", Cursor: raw("created")}},
+		{"object key", models.NoteQuery{Sort: "created", Cursor: raw(`{"s":"created","k":{"x":1},"i":1}`)}},
+		{"missing key", models.NoteQuery{Sort: "created", Cursor: raw(`{"s":"created","i":1}`)}},
+	}
+	for _, tt := range invalid {
+		tt.q.Limit = 2
+		if _, _, err := d.QueryNotes(tt.q); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: got %v, want ErrInvalidCursor", tt.name, err)
+		}
+	}
+}
+
+func TestQueryNotesPublicHidesLocked(t *testing.T) {
+	d, notes := newPagingDB(t)
+	var public []testNote
+	for _, n := range notes {
+		if n.public {
+			public = append(public, n)
+		}
+	}
+	if len(public) == 0 || len(public) == len(notes) {
+		t.Fatal("the fixture needs public and locked notes")
+	}
+
+	for _, sortBy := range []string{"name", "views"} {
+		got := pageThrough(t, d, models.NoteQuery{Sort: sortBy, Public: true, Limit: 3})
+		if want := fmt.Sprint(expectedOrder(public, sortBy, false)); fmt.Sprint(got) != want {
+			t.Errorf("public notes by %s:\ngot  %v\nwant %s", sortBy, got, want)
+		}
+	}
+
+	// Locked notes stay hidden when the category is listed directly
+	private := notes[len(notes)-1].categoryID
+	listed, _, err := d.QueryNotes(models.NoteQuery{CategoryID: private, Public: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 0 {
+		t.Fatalf("anonymous listing of a locked category returned %d notes", len(listed))
+	}
+	writer, _, err := d.QueryNotes(models.NoteQuery{CategoryID: private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(writer) != 7 {
+		t.Fatalf("writer listing of a locked category returned %d notes, want 7", len(writer))
+	}
+}
+
+func TestQueryNotesLanguage(t *testing.T) {
+	d := newTestDB(t)
+	category, err := d.CreateCategory("Docs", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Intro", "Intro__de", "Intro__fr.md", "Guide__DE", "Guide__en", "Setup.md", "snake__case__x"} {
+		if _, err := d.CreateNote(category.ID, name, "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string][]string{
+		"de": {"Guide__DE", "Intro__de"},
+		"fr": {"Intro__fr.md"},
+		"en": {"Guide__en", "Intro", "Setup.md", "snake__case__x"},
+		"es": nil,
+	}
+	for lang, want := range tests {
+		notes, _, err := d.QueryNotes(models.NoteQuery{Language: lang})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, n := range notes {
+			got = append(got, n.Name)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("lang %s: got %v, want %v", lang, got, want)
+		}
+	}
+}
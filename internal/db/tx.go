@@ -0,0 +1,55 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// execer is implemented by *sql.DB and *sql.Tx, so writes can run inside or outside a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Tx groups writes that are committed together or not at all, e.g. an import.
+// Reads through the DB don't see its writes before the commit, use the Tx methods instead.
+type Tx struct {
+	tx *sql.Tx
+}
+
+// Transaction runs fn in a transaction, committing when it returns nil and rolling back otherwise.
+// The writer connection is held until fn returns, so fn must not write through the DB.
+func (d *DB) Transaction(fn func(tx *Tx) error) error {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&Tx{tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CreateCategory creates a category and returns its ID
+func (t *Tx) CreateCategory(name, icon string) (int64, error) {
+	return createCategory(t.tx, name, icon)
+}
+
+// CreateNote creates a note and returns its ID
+func (t *Tx) CreateNote(categoryID int64, name, content, icon string) (int64, error) {
+	return createNote(t.tx, categoryID, name, content, icon)
+}
+
+func (t *Tx) UpdateNote(id, categoryID int64, name, content, icon string) error {
+	return updateNote(t.tx, id, categoryID, name, content, icon)
+}
+
+func (t *Tx) SetNoteTimestamps(id int64, createdAt, updatedAt time.Time) error {
+	return setNoteTimestamps(t.tx, id, createdAt, updatedAt)
+}
+
+func (t *Tx) IsCategoryPrivate(categoryID int64) (bool, error) {
+	return isCategoryPrivate(t.tx, categoryID)
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"lava-notes/internal/auth"
+	"lava-notes/internal/backup"
+	"lava-notes/internal/cache"
+	"lava-notes/internal/db"
+	"lava-notes/internal/models"
+	"lava-notes/internal/views"
+)
+
+type testServer struct {
+	h     *Handlers
+	db    *db.DB
+	auth  *auth.Auth
+	token string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+	database, err := db.New(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	c := cache.New[string, any](cache.Options[any]{TTL: time.Hour})
+	a := auth.New(database, "test-secret")
+	token, err := a.GenerateJWT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := backup.New(database, filepath.Join(dir, "backups"), backup.Policy{}, 0)
+	return &testServer{
+		h:     New(database, c, a, views.New(database), b),
+		db:    database,
+		auth:  a,
+		token: token,
+	}
+}
+
+// write sends a JSON request as the writer and fails the test on an unexpected status
+func (s *testServer) write(t *testing.T, handler http.HandlerFunc, method, path string, body interface{}, status int) {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	rec := httptest.NewRecorder()
+	s.auth.Middleware(handler, true)(rec, req)
+	if rec.Code != status {
+		t.Fatalf("%s %s: got %d, want %d: %s", method, path, rec.Code, status, rec.Body.String())
+	}
+}
+
+func (s *testServer) category(t *testing.T, name, icon string) *models.Category {
+	t.Helper()
+	category, err := s.db.CreateCategory(name, icon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return category
+}
+
+func (s *testServer) note(t *testing.T, categoryID int64, name, content string) *models.Note {
+	t.Helper()
+	note, err := s.db.CreateNote(categoryID, name, content, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return note
+}
+
+func (s *testServer) loadNote(t *testing.T, id int64) *models.Note {
+	t.Helper()
+	note, err := s.h.loadNote(id)
+	if err != nil {
+		t.Fatalf("loading note %d: %v", id, err)
+	}
+	return note
+}
+
+func (s *testServer) noteNames(t *testing.T, categoryID int64) []string {
+	t.Helper()
+	notes, err := s.h.loadNotes(categoryID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(notes))
+	for _, n := range notes {
+		names = append(names, n.Name)
+	}
+	return names
+}
+
+func (s *testServer) categoryNames(t *testing.T) []string {
+	t.Helper()
+	categories, err := s.h.loadCategories()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(categories))
+	for _, c := range categories {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+func expectNames(t *testing.T, what string, got []string, want ...string) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("%s: got %q, want %q", what, got, want)
+	}
+}
+
+func TestCacheCreateNote(t *testing.T) {
+	s := newTestServer(t)
+	cat := s.category(t, "Go", "")
+	s.note(t, cat.ID, "Alpha", "")
+	expectNames(t, "notes before create", s.noteNames(t, cat.ID), "Alpha")
+
+	s.write(t, s.h.CreateNote, http.MethodPost, "/api/notes",
+		map[string]interface{}{"category_id": cat.ID, "name": "Beta", "content": "b"}, http.StatusCreated)
+
+	expectNames(t, "notes after create", s.noteNames(t, cat.ID), "Alpha", "Beta")
+}
+
+func TestCacheUpdateNote(t *testing.T) {
+	s := newTestServer(t)
+	cat := s.category(t, "Go", "")
+	note := s.note(t, cat.ID, "Alpha", "old")
+	s.loadNote(t, note.ID)
+	expectNames(t, "notes before update", s.noteNames(t, cat.ID), "Alpha")
+
+	s.write(t, s.h.UpdateNote, http.MethodPut, fmt.Sprintf("/api/notes/%d", note.ID),
+		map[string]interface{}{"name": "Gamma", "content": "new"}, http.StatusOK)
+
+	got := s.loadNote(t, note.ID)
+	if got.Name != "Gamma" || got.Content != "new" {
+		t.Fatalf("note after update: %q %q", got.Name, got.Content)
+	}
+	expectNames(t, "notes after update", s.noteNames(t, cat.ID), "Gamma")
+}
+
+func TestCacheMoveNote(t *testing.T) {
+	s := newTestServer(t)
+	from := s.category(t, "From", "")
+	to := s.category(t, "To", "")
+	note := s.note(t, from.ID, "Alpha", "")
+	s.loadNote(t, note.ID)
+	expectNames(t, "source before move", s.noteNames(t, from.ID), "Alpha")
+	expectNames(t, "target before move", s.noteNames(t, to.ID))
+
+	s.write(t, s.h.UpdateNote, http.MethodPut, fmt.Sprintf("/api/notes/%d", note.ID),
+		map[string]interface{}{"category_id": to.ID, "name": "Alpha", "content": ""}, http.StatusOK)
+
+	if got := s.loadNote(t, note.ID); got.CategoryID != to.ID {
+		t.Fatalf("note after move is in category %d, want %d", got.CategoryID, to.ID)
+	}
+	expectNames(t, "source after move", s.noteNames(t, from.ID))
+	expectNames(t, "target after move", s.noteNames(t, to.ID), "Alpha")
+}
+
+func TestCacheDeleteNote(t *testing.T) {
+	s := newTestServer(t)
+	cat := s.category(t, "Go", "")
+	note := s.note(t, cat.ID, "Alpha", "")
+	s.loadNote(t, note.ID)
+	expectNames(t, "notes before delete", s.noteNames(t, cat.ID), "Alpha")
+
+	s.write(t, s.h.DeleteNote, http.MethodDelete, fmt.Sprintf("/api/notes/%d", note.ID), nil, http.StatusNoContent)
+
+	if _, err := s.h.loadNote(note.ID); err == nil {
+		t.Fatal("deleted note still loads")
+	}
+	expectNames(t, "notes after delete", s.noteNames(t, cat.ID))
+}
+
+func TestCacheRenameCategory(t *testing.T) {
+	s := newTestServer(t)
+	cat := s.category(t, "Go", "")
+	s.category(t, "Rust", "")
+	expectNames(t, "categories before rename", s.categoryNames(t), "Go", "Rust")
+
+	s.write(t, s.h.UpdateCategory, http.MethodPut, fmt.Sprintf("/api/categories/%d", cat.ID),
+		map[string]interface{}{"name": "Golang", "icon": "code"}, http.StatusOK)
+
+	expectNames(t, "categories after rename", s.categoryNames(t), "Golang", "Rust")
+	categories, _ := s.h.loadCategories()
+	if categories[0].Icon != "code" {
+		t.Fatalf("category icon after update: %q", categories[0].Icon)
+	}
+}
+
+func TestCacheDeleteCategory(t *testing.T) {
+	s := newTestServer(t)
+	cat := s.category(t, "Go", "")
+	other := s.category(t, "Rust", "")
+	note := s.note(t, cat.ID, "Alpha", "")
+	kept := s.note(t, other.ID, "Beta", "")
+	s.loadNote(t, note.ID)
+	s.loadNote(t, kept.ID)
+	expectNames(t, "categories before delete", s.categoryNames(t), "Go", "Rust")
+	expectNames(t, "notes before delete", s.noteNames(t, cat.ID), "Alpha")
+
+	s.write(t, s.h.DeleteCategory, http.MethodDelete, fmt.Sprintf("/api/categories/%d", cat.ID), nil, http.StatusNoContent)
+
+	expectNames(t, "categories after delete", s.categoryNames(t), "Rust")
+	expectNames(t, "notes after delete", s.noteNames(t, cat.ID))
+	if _, err := s.h.loadNote(note.ID); err == nil {
+		t.Fatal("note of a deleted category still loads")
+	}
+	if got := s.loadNote(t, kept.ID); got.Name != "Beta" {
+		t.Fatalf("note of another category: %q", got.Name)
+	}
+}
@@ -1,32 +1,47 @@
 package handlers
 
 import (
+	"archive/zip"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
+	"io"
+	"log"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"lava-notes/internal/apierror"
+	"lava-notes/internal/archive"
 	"lava-notes/internal/auth"
+	"lava-notes/internal/backup"
 	"lava-notes/internal/cache"
 	"lava-notes/internal/db"
 	"lava-notes/internal/models"
+	"lava-notes/internal/openapi"
+	"lava-notes/internal/validate"
 	"lava-notes/internal/views"
 )
 
 type Handlers struct {
-	db    *db.DB
-	cache *cache.Cache
-	auth  *auth.Auth
-	views *views.Views
+	db      *db.DB
+	cache   *cache.Cache[string, any]
+	auth    *auth.Auth
+	views   *views.Views
+	backups *backup.Manager
 }
 
-func New(database *db.DB, c *cache.Cache, a *auth.Auth, v *views.Views) *Handlers {
+func New(database *db.DB, c *cache.Cache[string, any], a *auth.Auth, v *views.Views, b *backup.Manager) *Handlers {
 	return &Handlers{
-		db:    database,
-		cache: c,
-		auth:  a,
-		views: v,
+		db:      database,
+		cache:   c,
+		auth:    a,
+		views:   v,
+		backups: b,
 	}
 }
 
@@ -38,8 +53,8 @@ func (h *Handlers) respond(w http.ResponseWriter, data interface{}, status int)
 	}
 }
 
-func (h *Handlers) error(w http.ResponseWriter, message string, status int) {
-	h.respond(w, map[string]string{"error": message}, status)
+func (h *Handlers) error(w http.ResponseWriter, r *http.Request, err *apierror.Error) {
+	apierror.Write(w, r, err)
 }
 
 type NoteWithViews struct {
@@ -56,16 +71,69 @@ func (h *Handlers) respondWithViews(w http.ResponseWriter, note *models.Note, st
 	h.respond(w, response, status)
 }
 
+// Cached loaders, concurrent misses for the same key share one database query
+
+func (h *Handlers) loadNote(id int64) (*models.Note, error) {
+	v, err := h.cache.GetOrLoad(cache.NoteKey(id), func() (any, []string, error) {
+		note, err := h.db.GetNote(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		return note, []string{cache.NoteTag(id), cache.CategoryTag(note.CategoryID)}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*models.Note), nil
+}
+
+func (h *Handlers) loadNotes(categoryID int64) ([]models.NoteListItem, error) {
+	v, err := h.cache.GetOrLoad(cache.NotesKey(categoryID), func() (any, []string, error) {
+		notes, err := h.db.GetNotes(categoryID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if notes == nil {
+			notes = []models.NoteListItem{}
+		}
+		return notes, []string{cache.CategoryTag(categoryID)}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]models.NoteListItem), nil
+}
+
+func (h *Handlers) loadCategories() ([]models.Category, error) {
+	v, err := h.cache.GetOrLoad(cache.CategoriesKey, func() (any, []string, error) {
+		categories, err := h.db.GetCategories()
+		if err != nil {
+			return nil, nil, err
+		}
+		if categories == nil {
+			categories = []models.Category{}
+		}
+		return categories, nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]models.Category), nil
+}
+
+// WarmNote preloads a note into the cache
+func (h *Handlers) WarmNote(id int64) error {
+	_, err := h.loadNote(id)
+	return err
+}
+
 // Categories
 func (h *Handlers) GetCategories(w http.ResponseWriter, r *http.Request) {
-	categories, err := h.db.GetCategories()
+	categories, err := h.loadCategories()
 	if err != nil {
-		h.error(w, "Failed to get categories", http.StatusInternalServerError)
+		h.error(w, r, apierror.Internal("Failed to get categories"))
 		return
 	}
-	if categories == nil {
-		categories = []models.Category{}
-	}
 
 	// Filter out locked categories for unauthorized users
 	if !auth.IsWriter(r) {
@@ -85,19 +153,19 @@ func (h *Handlers) GetCategory(w http.ResponseWriter, r *http.Request) {
 	idStr := strings.TrimPrefix(r.URL.Path, "/api/categories/")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		h.error(w, "Invalid category ID", http.StatusBadRequest)
+		h.error(w, r, apierror.Invalid("id", "Invalid category ID"))
 		return
 	}
 
 	category, err := h.db.GetCategory(id)
 	if err != nil {
-		h.error(w, "Category not found", http.StatusNotFound)
+		h.error(w, r, apierror.ErrCategoryNotFound)
 		return
 	}
 
 	// Block locked categories for unauthorized users
 	if category.Icon == "lock" && !auth.IsWriter(r) {
-		h.error(w, "Category not found", http.StatusNotFound)
+		h.error(w, r, apierror.ErrCategoryNotFound)
 		return
 	}
 
@@ -106,7 +174,7 @@ func (h *Handlers) GetCategory(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handlers) CreateCategory(w http.ResponseWriter, r *http.Request) {
 	if !auth.IsWriter(r) {
-		h.error(w, "Unauthorized", http.StatusUnauthorized)
+		h.error(w, r, apierror.ErrUnauthorized)
 		return
 	}
 
@@ -114,35 +182,43 @@ func (h *Handlers) CreateCategory(w http.ResponseWriter, r *http.Request) {
 		Name string `json:"name"`
 		Icon string `json:"icon"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.error(w, "Invalid request body", http.StatusBadRequest)
+	if apiErr := decodeJSON(w, r, &req, maxJSONBody); apiErr != nil {
+		h.error(w, r, apiErr)
 		return
 	}
 
-	if req.Name == "" {
-		h.error(w, "Name is required", http.StatusBadRequest)
+	var v validator
+	v.name("name", req.Name, validate.MaxCategoryNameLength)
+	v.icon("icon", req.Icon)
+	if apiErr := v.err(); apiErr != nil {
+		h.error(w, r, apiErr)
 		return
 	}
 
 	category, err := h.db.CreateCategory(req.Name, req.Icon)
+	if errors.Is(err, db.ErrConflict) {
+		h.error(w, r, errCategoryConflict)
+		return
+	}
 	if err != nil {
-		h.error(w, "Failed to create category", http.StatusInternalServerError)
+		h.error(w, r, apierror.Internal("Failed to create category"))
 		return
 	}
 
+	h.cache.Invalidate(cache.CategoriesKey)
 	h.respond(w, category, http.StatusCreated)
 }
 
 func (h *Handlers) UpdateCategory(w http.ResponseWriter, r *http.Request) {
 	if !auth.IsWriter(r) {
-		h.error(w, "Unauthorized", http.StatusUnauthorized)
+		h.error(w, r, apierror.ErrUnauthorized)
 		return
 	}
 
 	idStr := strings.TrimPrefix(r.URL.Path, "/api/categories/")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		h.error(w, "Invalid category ID", http.StatusBadRequest)
+		h.error(w, r, apierror.Invalid("id", "Invalid category ID"))
 		return
 	}
 
@@ -150,72 +226,121 @@ func (h *Handlers) UpdateCategory(w http.ResponseWriter, r *http.Request) {
 		Name string `json:"name"`
 		Icon string `json:"icon"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.error(w, "Invalid request body", http.StatusBadRequest)
+	if apiErr := decodeJSON(w, r, &req, maxJSONBody); apiErr != nil {
+		h.error(w, r, apiErr)
+		return
+	}
+
+	var v validator
+	v.name("name", req.Name, validate.MaxCategoryNameLength)
+	v.icon("icon", req.Icon)
+	if apiErr := v.err(); apiErr != nil {
+		h.error(w, r, apiErr)
+		return
+	}
+
+	if _, err := h.db.GetCategory(id); err != nil {
+		h.error(w, r, apierror.ErrCategoryNotFound)
 		return
 	}
 
 	category, err := h.db.UpdateCategory(id, req.Name, req.Icon)
+	if errors.Is(err, db.ErrConflict) {
+		h.error(w, r, errCategoryConflict)
+		return
+	}
 	if err != nil {
-		h.error(w, "Failed to update category", http.StatusInternalServerError)
+		h.error(w, r, apierror.Internal("Failed to update category"))
 		return
 	}
 
-	h.cache.InvalidateByPrefix(fmt.Sprintf("note:%d:", id))
+	h.cache.Invalidate(cache.CategoriesKey)
+	h.cache.InvalidateTag(cache.CategoryTag(id))
 	h.respond(w, category, http.StatusOK)
 }
 
 func (h *Handlers) DeleteCategory(w http.ResponseWriter, r *http.Request) {
 	if !auth.IsWriter(r) {
-		h.error(w, "Unauthorized", http.StatusUnauthorized)
+		h.error(w, r, apierror.ErrUnauthorized)
 		return
 	}
 
 	idStr := strings.TrimPrefix(r.URL.Path, "/api/categories/")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		h.error(w, "Invalid category ID", http.StatusBadRequest)
+		h.error(w, r, apierror.Invalid("id", "Invalid category ID"))
 		return
 	}
 
+	// Notes are deleted by CASCADE, remember them to clean up their views
+	notes, _ := h.db.GetNotes(id)
+
 	if err := h.db.DeleteCategory(id); err != nil {
-		h.error(w, "Failed to delete category", http.StatusInternalServerError)
+		h.error(w, r, apierror.Internal("Failed to delete category"))
 		return
 	}
 
-	h.cache.InvalidateByPrefix(fmt.Sprintf("note:%d:", id))
+	noteIDs := make([]int64, 0, len(notes))
+	for _, n := range notes {
+		noteIDs = append(noteIDs, n.ID)
+	}
+	h.views.Forget(noteIDs...)
+
+	// The tag covers the deleted notes and the note list
+	h.cache.Invalidate(cache.CategoriesKey)
+	h.cache.InvalidateTag(cache.CategoryTag(id))
 	h.respond(w, nil, http.StatusNoContent)
 }
 
 // Notes
+// Note listings are paginated with limit and cursor, the next cursor is sent in a header
+const (
+	maxNotesLimit     = 200
+	defaultRecentSize = 20
+	nextCursorHeader  = "X-Next-Cursor"
+)
+
+var languageRe = regexp.MustCompile(`^[a-z]{2}$`)
+
+// noteListParams are the query parameters that select a page instead of a whole category
+var noteListParams = []string{"limit", "cursor", "sort", "order", "icon", "lang", "updated_since"}
+
 func (h *Handlers) GetNotes(w http.ResponseWriter, r *http.Request) {
-	categoryIDStr := r.URL.Query().Get("category_id")
-	if categoryIDStr == "" {
-		h.error(w, "category_id is required", http.StatusBadRequest)
+	query := r.URL.Query()
+	paged := false
+	for _, p := range noteListParams {
+		if query.Has(p) {
+			paged = true
+		}
+	}
+	if !paged && query.Get("category_id") == "" {
+		h.error(w, r, apierror.Invalid("category_id", "category_id is required"))
 		return
 	}
 
-	categoryID, err := strconv.ParseInt(categoryIDStr, 10, 64)
-	if err != nil {
-		h.error(w, "Invalid category_id", http.StatusBadRequest)
+	q, apiErr := parseNoteQuery(r)
+	if apiErr != nil {
+		h.error(w, r, apiErr)
 		return
 	}
 
 	// Check if category is locked for non-writers
-	if !auth.IsWriter(r) {
-		if isPrivate, err := h.db.IsCategoryPrivate(categoryID); err != nil || isPrivate {
-			h.error(w, "Category not found", http.StatusNotFound)
+	if q.CategoryID != 0 && !auth.IsWriter(r) {
+		if isPrivate, err := h.db.IsCategoryPrivate(q.CategoryID); err != nil || isPrivate {
+			h.error(w, r, apierror.ErrCategoryNotFound)
 			return
 		}
 	}
 
-	notes, err := h.db.GetNotes(categoryID)
-	if err != nil {
-		h.error(w, "Failed to get notes", http.StatusInternalServerError)
+	if paged {
+		h.listNotes(w, r, q)
 		return
 	}
-	if notes == nil {
-		notes = []models.NoteListItem{}
+
+	notes, err := h.loadNotes(q.CategoryID)
+	if err != nil {
+		h.error(w, r, apierror.Internal("Failed to get notes"))
+		return
 	}
 
 	// Filter out locked notes for unauthorized users
@@ -232,52 +357,147 @@ func (h *Handlers) GetNotes(w http.ResponseWriter, r *http.Request) {
 	h.respond(w, notes, http.StatusOK)
 }
 
-func (h *Handlers) GetNote(w http.ResponseWriter, r *http.Request) {
-	idStr := strings.TrimPrefix(r.URL.Path, "/api/notes/")
-	id, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		h.error(w, "Invalid note ID", http.StatusBadRequest)
+// GetRecentNotes lists recently updated notes across categories
+func (h *Handlers) GetRecentNotes(w http.ResponseWriter, r *http.Request) {
+	q, apiErr := parseNoteQuery(r)
+	if apiErr != nil {
+		h.error(w, r, apiErr)
 		return
 	}
+	q.Sort, q.Desc = "updated", true
+	if q.Limit == 0 {
+		q.Limit = defaultRecentSize
+	}
+	h.listNotes(w, r, q)
+}
 
-	cacheKey := fmt.Sprintf("note:%d", id)
-	if note, ok := h.cache.Get(cacheKey); ok {
-		// Block locked notes for unauthorized users
-		if note.Icon == "lock" && !auth.IsWriter(r) {
-			h.error(w, "Note not found", http.StatusNotFound)
+func (h *Handlers) listNotes(w http.ResponseWriter, r *http.Request, q models.NoteQuery) {
+	q.Public = !auth.IsWriter(r)
+	if q.Sort == "views" {
+		// View counts are only shown to writers
+		if q.Public {
+			h.error(w, r, apierror.ErrUnauthorized)
 			return
 		}
-		// Record view
-		if ipHeader := h.views.GetIPHeaderName(); ipHeader != "" {
-			h.views.RecordView(id, r.Header.Get(ipHeader))
+		if err := h.views.Flush(); err != nil {
+			log.Printf("Failed to flush views: %v", err)
 		}
-		h.respondWithViews(w, note, http.StatusOK, r)
+	}
+
+	notes, next, err := h.db.QueryNotes(q)
+	if errors.Is(err, db.ErrInvalidCursor) {
+		h.error(w, r, apierror.Invalid("cursor", "Invalid cursor"))
+		return
+	}
+	if err != nil {
+		h.error(w, r, apierror.Internal("Failed to get notes"))
 		return
 	}
+	if next != "" {
+		w.Header().Set(nextCursorHeader, next)
+	}
+	h.respond(w, notes, http.StatusOK)
+}
+
+// parseNoteQuery reads listing parameters, rejecting invalid ones
+func parseNoteQuery(r *http.Request) (models.NoteQuery, *apierror.Error) {
+	query := r.URL.Query()
+	q := models.NoteQuery{
+		Sort:   query.Get("sort"),
+		Icon:   query.Get("icon"),
+		Cursor: query.Get("cursor"),
+	}
+	var fields []apierror.FieldError
+
+	if s := query.Get("category_id"); s != "" {
+		if id, err := strconv.ParseInt(s, 10, 64); err == nil {
+			q.CategoryID = id
+		} else {
+			fields = append(fields, apierror.FieldError{Field: "category_id", Message: "Invalid category_id"})
+		}
+	}
 
-	note, err := h.db.GetNote(id)
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxNotesLimit {
+			fields = append(fields, apierror.FieldError{Field: "limit", Message: fmt.Sprintf("limit must be between 1 and %d", maxNotesLimit)})
+		} else {
+			q.Limit = limit
+		}
+	}
+
+	switch q.Sort {
+	case "":
+		q.Sort = "name"
+	case "name", "created", "updated", "views":
+	default:
+		fields = append(fields, apierror.FieldError{Field: "sort", Message: "sort must be one of name, created, updated, views"})
+	}
+
+	switch query.Get("order") {
+	case "":
+		// Newest and most viewed first unless asked otherwise
+		q.Desc = q.Sort != "name"
+	case "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		fields = append(fields, apierror.FieldError{Field: "order", Message: "order must be asc or desc"})
+	}
+
+	if lang := strings.ToLower(query.Get("lang")); lang != "" {
+		if languageRe.MatchString(lang) {
+			q.Language = lang
+		} else {
+			fields = append(fields, apierror.FieldError{Field: "lang", Message: "lang must be a two-letter code"})
+		}
+	}
+
+	if s := query.Get("updated_since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t, err = time.Parse(time.DateOnly, s)
+		}
+		if err != nil {
+			fields = append(fields, apierror.FieldError{Field: "updated_since", Message: "updated_since must be an RFC 3339 time or a date"})
+		}
+		q.UpdatedSince = t
+	}
+
+	if len(fields) > 0 {
+		return q, apierror.Validation(fields...)
+	}
+	return q, nil
+}
+
+func (h *Handlers) GetNote(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/notes/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		h.error(w, r, apierror.Invalid("id", "Invalid note ID"))
+		return
+	}
+
+	note, err := h.loadNote(id)
 	if err != nil {
-		h.error(w, "Note not found", http.StatusNotFound)
+		h.error(w, r, apierror.ErrNoteNotFound)
 		return
 	}
 
 	// Block locked notes for unauthorized users
 	if note.Icon == "lock" && !auth.IsWriter(r) {
-		h.error(w, "Note not found", http.StatusNotFound)
+		h.error(w, r, apierror.ErrNoteNotFound)
 		return
 	}
 
-	h.cache.Set(cacheKey, note)
 	// Record view
-	if ipHeader := h.views.GetIPHeaderName(); ipHeader != "" {
-		h.views.RecordView(id, r.Header.Get(ipHeader))
-	}
+	h.views.RecordView(id, h.views.VisitFromRequest(r))
 	h.respondWithViews(w, note, http.StatusOK, r)
 }
 
 func (h *Handlers) CreateNote(w http.ResponseWriter, r *http.Request) {
 	if !auth.IsWriter(r) {
-		h.error(w, "Unauthorized", http.StatusUnauthorized)
+		h.error(w, r, apierror.ErrUnauthorized)
 		return
 	}
 
@@ -287,107 +507,197 @@ func (h *Handlers) CreateNote(w http.ResponseWriter, r *http.Request) {
 		Content    string `json:"content"`
 		Icon       string `json:"icon"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.error(w, "Invalid request body", http.StatusBadRequest)
+	if apiErr := decodeJSON(w, r, &req, maxNoteBody); apiErr != nil {
+		h.error(w, r, apiErr)
 		return
 	}
 
-	if req.CategoryID == 0 || req.Name == "" {
-		h.error(w, "category_id and name are required", http.StatusBadRequest)
+	var v validator
+	if req.CategoryID == 0 {
+		v.add("category_id", "category_id is required")
+	}
+	v.name("name", req.Name, validate.MaxNameLength)
+	v.content("content", req.Content)
+	v.icon("icon", req.Icon)
+	if apiErr := v.err(); apiErr != nil {
+		h.error(w, r, apiErr)
 		return
 	}
 
-	if isPrivate, _ := h.db.IsCategoryPrivate(req.CategoryID); isPrivate {
+	isPrivate, err := h.db.IsCategoryPrivate(req.CategoryID)
+	if err != nil {
+		h.error(w, r, apierror.ErrCategoryNotFound)
+		return
+	}
+	if isPrivate {
 		req.Icon = "lock"
 	}
 
 	note, err := h.db.CreateNote(req.CategoryID, req.Name, req.Content, req.Icon)
+	if errors.Is(err, db.ErrConflict) {
+		h.error(w, r, errNoteConflict)
+		return
+	}
 	if err != nil {
-		h.error(w, "Failed to create note", http.StatusInternalServerError)
+		h.error(w, r, apierror.Internal("Failed to create note"))
 		return
 	}
 
+	h.cache.Invalidate(cache.NotesKey(note.CategoryID))
 	h.respond(w, note, http.StatusCreated)
 }
 
 func (h *Handlers) UpdateNote(w http.ResponseWriter, r *http.Request) {
 	if !auth.IsWriter(r) {
-		h.error(w, "Unauthorized", http.StatusUnauthorized)
+		h.error(w, r, apierror.ErrUnauthorized)
 		return
 	}
 
 	idStr := strings.TrimPrefix(r.URL.Path, "/api/notes/")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		h.error(w, "Invalid note ID", http.StatusBadRequest)
+		h.error(w, r, apierror.Invalid("id", "Invalid note ID"))
 		return
 	}
 
 	var req struct {
-		Name    string `json:"name"`
-		Content string `json:"content"`
-		Icon    string `json:"icon"`
+		CategoryID int64  `json:"category_id"` // optional, moves the note
+		Name       string `json:"name"`
+		Content    string `json:"content"`
+		Icon       string `json:"icon"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.error(w, "Invalid request body", http.StatusBadRequest)
+	if apiErr := decodeJSON(w, r, &req, maxNoteBody); apiErr != nil {
+		h.error(w, r, apiErr)
+		return
+	}
+
+	var v validator
+	v.name("name", req.Name, validate.MaxNameLength)
+	v.content("content", req.Content)
+	v.icon("icon", req.Icon)
+	if apiErr := v.err(); apiErr != nil {
+		h.error(w, r, apiErr)
 		return
 	}
 
 	existingNote, err := h.db.GetNote(id)
 	if err != nil {
-		h.error(w, "Note not found", http.StatusNotFound)
+		h.error(w, r, apierror.ErrNoteNotFound)
 		return
 	}
-	if isPrivate, _ := h.db.IsCategoryPrivate(existingNote.CategoryID); isPrivate {
+	if req.CategoryID == 0 {
+		req.CategoryID = existingNote.CategoryID
+	}
+	isPrivate, err := h.db.IsCategoryPrivate(req.CategoryID)
+	if err != nil {
+		h.error(w, r, apierror.ErrCategoryNotFound)
+		return
+	}
+	if isPrivate {
 		req.Icon = "lock"
 	}
 
-	note, err := h.db.UpdateNote(id, req.Name, req.Content, req.Icon)
+	note, err := h.db.UpdateNote(id, req.CategoryID, req.Name, req.Content, req.Icon)
+	if errors.Is(err, db.ErrConflict) {
+		h.error(w, r, errNoteConflict)
+		return
+	}
 	if err != nil {
-		h.error(w, "Failed to update note", http.StatusInternalServerError)
+		h.error(w, r, apierror.Internal("Failed to update note"))
 		return
 	}
 
-	h.cache.Invalidate(fmt.Sprintf("note:%d", id))
+	h.cache.InvalidateTag(cache.NoteTag(id))
+	h.cache.Invalidate(cache.NotesKey(existingNote.CategoryID))
+	h.cache.Invalidate(cache.NotesKey(note.CategoryID))
 	h.respondWithViews(w, note, http.StatusOK, r)
 }
 
 func (h *Handlers) DeleteNote(w http.ResponseWriter, r *http.Request) {
 	if !auth.IsWriter(r) {
-		h.error(w, "Unauthorized", http.StatusUnauthorized)
+		h.error(w, r, apierror.ErrUnauthorized)
 		return
 	}
 
 	idStr := strings.TrimPrefix(r.URL.Path, "/api/notes/")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		h.error(w, "Invalid note ID", http.StatusBadRequest)
+		h.error(w, r, apierror.Invalid("id", "Invalid note ID"))
 		return
 	}
 
+	// Look up the category first so its note list can be evicted
+	existingNote, _ := h.db.GetNote(id)
+
 	if err := h.db.DeleteNote(id); err != nil {
-		h.error(w, "Failed to delete note", http.StatusInternalServerError)
+		h.error(w, r, apierror.Internal("Failed to delete note"))
 		return
 	}
 
-	h.cache.Invalidate(fmt.Sprintf("note:%d", id))
+	h.views.Forget(id)
+	h.cache.InvalidateTag(cache.NoteTag(id))
+	if existingNote != nil {
+		h.cache.Invalidate(cache.NotesKey(existingNote.CategoryID))
+	}
 	h.respond(w, nil, http.StatusNoContent)
 }
 
 // Auth
+var secondFactorForm = template.Must(template.New("2fa").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>Lava Notes</title>
+</head>
+<body style="background:#0d0d0d;color:#e8e8e8;font-family:sans-serif;display:flex;justify-content:center;padding-top:15vh">
+<form method="POST" action="login">
+<input type="hidden" name="token" value="{{.Token}}">
+<p>{{if .Failed}}Invalid code, try again.{{else}}Enter authenticator or recovery code{{end}}</p>
+<input name="code" autocomplete="one-time-code" inputmode="numeric" autofocus required>
+<button type="submit">Log in</button>
+</form>
+</body>
+</html>`))
+
 func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
-	token := r.URL.Query().Get("token")
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONBody)
+	token := r.FormValue("token")
 	if token == "" {
-		h.error(w, "Token is required", http.StatusBadRequest)
+		h.error(w, r, apierror.Invalid("token", "Token is required"))
+		return
+	}
+
+	// Ask for the second factor before consuming the token
+	code := r.PostFormValue("code")
+	if code == "" && h.auth.TOTPEnabled() {
+		if err := h.auth.CheckLoginToken(token); err != nil {
+			h.error(w, r, apierror.New(http.StatusUnauthorized, apierror.CodeInvalidLoginLink, "Invalid or expired login link"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		secondFactorForm.Execute(w, map[string]interface{}{"Token": token, "Failed": false})
 		return
 	}
 
-	jwt, err := h.auth.ValidateLoginToken(token)
+	jwt, err := h.auth.ValidateLoginToken(token, code)
+	if errors.Is(err, auth.ErrInvalidCode) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusUnauthorized)
+		secondFactorForm.Execute(w, map[string]interface{}{"Token": token, "Failed": true})
+		return
+	}
 	if err != nil {
-		h.error(w, err.Error(), http.StatusUnauthorized)
+		// Don't reveal whether the token exists, was used or has expired
+		h.error(w, r, apierror.New(http.StatusUnauthorized, apierror.CodeInvalidLoginLink, "Invalid or expired login link"))
 		return
 	}
 
+	setAuthCookie(w, jwt)
+	http.Redirect(w, r, "../../", http.StatusFound)
+}
+
+func setAuthCookie(w http.ResponseWriter, jwt string) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "lava_token",
 		Value:    jwt,
@@ -396,8 +706,263 @@ func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
 		HttpOnly: true,
 		SameSite: http.SameSiteStrictMode,
 	})
+}
 
-	http.Redirect(w, r, "../../", http.StatusFound)
+// TOTP second factor
+func (h *Handlers) GetTOTP(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsWriter(r) {
+		h.error(w, r, apierror.ErrUnauthorized)
+		return
+	}
+
+	h.respond(w, map[string]interface{}{
+		"enabled":        h.auth.TOTPEnabled(),
+		"recovery_codes": h.auth.RemainingRecoveryCodes(),
+	}, http.StatusOK)
+}
+
+func (h *Handlers) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsWriter(r) {
+		h.error(w, r, apierror.ErrUnauthorized)
+		return
+	}
+
+	if h.auth.TOTPEnabled() {
+		h.error(w, r, apierror.New(http.StatusConflict, apierror.CodeConflict, "TOTP is already enabled"))
+		return
+	}
+
+	secret, uri, err := h.auth.BeginTOTPEnrollment("Lava Notes")
+	if err != nil {
+		h.error(w, r, apierror.Internal("Failed to start enrollment"))
+		return
+	}
+
+	h.respond(w, map[string]string{"secret": secret, "uri": uri}, http.StatusOK)
+}
+
+func (h *Handlers) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsWriter(r) {
+		h.error(w, r, apierror.ErrUnauthorized)
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if apiErr := decodeJSON(w, r, &req, maxJSONBody); apiErr != nil {
+		h.error(w, r, apiErr)
+		return
+	}
+
+	codes, err := h.auth.ConfirmTOTP(req.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidCode):
+			h.error(w, r, apierror.New(http.StatusBadRequest, apierror.CodeInvalidCode, "Invalid code"))
+		case errors.Is(err, auth.ErrTOTPNotPending):
+			h.error(w, r, apierror.New(http.StatusConflict, apierror.CodeConflict, "No pending enrollment"))
+		default:
+			h.error(w, r, apierror.Internal("Failed to enable TOTP"))
+		}
+		return
+	}
+
+	h.respond(w, map[string]interface{}{"recovery_codes": codes}, http.StatusOK)
+}
+
+func (h *Handlers) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsWriter(r) {
+		h.error(w, r, apierror.ErrUnauthorized)
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if apiErr := decodeJSON(w, r, &req, maxJSONBody); apiErr != nil {
+		h.error(w, r, apiErr)
+		return
+	}
+
+	// Require a fresh code so a stolen session can't silently drop the second factor
+	if err := h.auth.VerifySecondFactor(req.Code); err != nil {
+		h.error(w, r, apierror.New(http.StatusBadRequest, apierror.CodeInvalidCode, "Invalid code"))
+		return
+	}
+
+	if err := h.auth.ResetTOTP(); err != nil {
+		h.error(w, r, apierror.Internal("Failed to disable TOTP"))
+		return
+	}
+
+	h.respond(w, nil, http.StatusNoContent)
+}
+
+// WebAuthn passkeys
+func (h *Handlers) WebAuthnRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsWriter(r) {
+		h.error(w, r, apierror.ErrUnauthorized)
+		return
+	}
+
+	options, err := h.auth.BeginRegistration()
+	if err != nil {
+		h.error(w, r, apierror.Internal("Failed to start registration"))
+		return
+	}
+
+	h.respond(w, map[string]interface{}{"publicKey": options}, http.StatusOK)
+}
+
+func (h *Handlers) WebAuthnRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsWriter(r) {
+		h.error(w, r, apierror.ErrUnauthorized)
+		return
+	}
+
+	var req struct {
+		Name       string                  `json:"name"`
+		Credential auth.CredentialResponse `json:"credential"`
+	}
+	if apiErr := decodeJSON(w, r, &req, maxJSONBody); apiErr != nil {
+		h.error(w, r, apiErr)
+		return
+	}
+
+	if err := h.auth.FinishRegistration(&req.Credential, req.Name); err != nil {
+		h.error(w, r, apierror.New(http.StatusBadRequest, apierror.CodePasskeyFailed, "Passkey registration failed"))
+		return
+	}
+
+	h.respond(w, map[string]string{"status": "ok"}, http.StatusCreated)
+}
+
+func (h *Handlers) WebAuthnLoginBegin(w http.ResponseWriter, r *http.Request) {
+	options, err := h.auth.BeginLogin()
+	if err != nil {
+		h.error(w, r, apierror.Internal("Failed to start login"))
+		return
+	}
+
+	h.respond(w, map[string]interface{}{"publicKey": options}, http.StatusOK)
+}
+
+func (h *Handlers) WebAuthnLoginFinish(w http.ResponseWriter, r *http.Request) {
+	var req auth.CredentialResponse
+	if apiErr := decodeJSON(w, r, &req, maxJSONBody); apiErr != nil {
+		h.error(w, r, apiErr)
+		return
+	}
+
+	jwt, err := h.auth.FinishLogin(&req)
+	if err != nil {
+		h.error(w, r, apierror.New(http.StatusUnauthorized, apierror.CodePasskeyFailed, "Passkey login failed"))
+		return
+	}
+
+	setAuthCookie(w, jwt)
+	h.respond(w, map[string]string{"status": "ok"}, http.StatusOK)
+}
+
+func (h *Handlers) GetPasskeys(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsWriter(r) {
+		h.error(w, r, apierror.ErrUnauthorized)
+		return
+	}
+
+	creds, err := h.db.GetWebAuthnCredentials()
+	if err != nil {
+		h.error(w, r, apierror.Internal("Failed to get passkeys"))
+		return
+	}
+	if creds == nil {
+		creds = []models.WebAuthnCredential{}
+	}
+
+	h.respond(w, creds, http.StatusOK)
+}
+
+func (h *Handlers) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsWriter(r) {
+		h.error(w, r, apierror.ErrUnauthorized)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/auth/webauthn/credentials/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		h.error(w, r, apierror.Invalid("id", "Invalid passkey ID"))
+		return
+	}
+
+	if err := h.db.DeleteWebAuthnCredential(id); err != nil {
+		h.error(w, r, apierror.Internal("Failed to delete passkey"))
+		return
+	}
+
+	h.respond(w, nil, http.StatusNoContent)
+}
+
+var passkeyPage = template.Must(template.New("passkey").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>Lava Notes</title>
+</head>
+<body style="background:#0d0d0d;color:#e8e8e8;font-family:sans-serif;display:flex;flex-direction:column;align-items:center;padding-top:15vh;gap:1em">
+{{if .Writer}}<input id="name" placeholder="Device name"><button id="register">Register passkey on this device</button>
+{{else}}<button id="login">Log in with passkey</button>{{end}}
+<p id="status"></p>
+<script>
+const b64 = (buf) => btoa(String.fromCharCode(...new Uint8Array(buf))).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+const unb64 = (s) => Uint8Array.from(atob(s.replace(/-/g, "+").replace(/_/g, "/")), (c) => c.charCodeAt(0));
+const status = (t) => (document.getElementById("status").textContent = t);
+const post = async (path, body) => {
+  const res = await fetch("../api/auth/webauthn/" + path, { method: "POST", headers: { "Content-Type": "application/json" }, body: JSON.stringify(body || {}) });
+  if (!res.ok) throw new Error((await res.json()).error);
+  return res.json();
+};
+const encode = (cred) => {
+  const r = cred.response, out = { id: cred.id, type: cred.type, response: { clientDataJSON: b64(r.clientDataJSON) } };
+  if (r.attestationObject) out.response.attestationObject = b64(r.attestationObject);
+  if (r.authenticatorData) out.response.authenticatorData = b64(r.authenticatorData);
+  if (r.signature) out.response.signature = b64(r.signature);
+  if (r.userHandle) out.response.userHandle = b64(r.userHandle);
+  return out;
+};
+const register = document.getElementById("register");
+if (register) register.onclick = async () => {
+  try {
+    const { publicKey } = await post("register/begin");
+    publicKey.challenge = unb64(publicKey.challenge);
+    publicKey.user.id = unb64(publicKey.user.id);
+    publicKey.excludeCredentials.forEach((c) => (c.id = unb64(c.id)));
+    const cred = await navigator.credentials.create({ publicKey });
+    await post("register/finish", { name: document.getElementById("name").value, credential: encode(cred) });
+    status("Passkey registered");
+  } catch (e) { status(e.message); }
+};
+const login = document.getElementById("login");
+if (login) login.onclick = async () => {
+  try {
+    const { publicKey } = await post("login/begin");
+    publicKey.challenge = unb64(publicKey.challenge);
+    publicKey.allowCredentials.forEach((c) => (c.id = unb64(c.id)));
+    const cred = await navigator.credentials.get({ publicKey });
+    await post("login/finish", encode(cred));
+    location.href = "../";
+  } catch (e) { status(e.message); }
+};
+</script>
+</body>
+</html>`))
+
+// PasskeyPage serves a minimal page for enrolling passkeys and logging in with them
+func (h *Handlers) PasskeyPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	passkeyPage.Execute(w, map[string]bool{"Writer": auth.IsWriter(r)})
 }
 
 func (h *Handlers) CheckAuth(w http.ResponseWriter, r *http.Request) {
@@ -412,26 +977,299 @@ func (h *Handlers) Logout(w http.ResponseWriter, r *http.Request) {
 		Path:     "/",
 		MaxAge:   -1,
 		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
 	})
 	h.respond(w, map[string]string{"status": "ok"}, http.StatusOK)
 }
 
+// Analytics
+func (h *Handlers) GetNoteAnalytics(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsWriter(r) {
+		h.error(w, r, apierror.ErrUnauthorized)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/api/analytics/notes/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		h.error(w, r, apierror.Invalid("id", "Invalid note ID"))
+		return
+	}
+
+	from, to, err := views.ParseRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if errors.Is(err, views.ErrRangeTooLong) {
+		h.error(w, r, apierror.Invalid("to", fmt.Sprintf("Range must span at most %d days", views.MaxRangeDays)))
+		return
+	}
+	if err != nil {
+		h.error(w, r, apierror.Validation(
+			apierror.FieldError{Field: "from", Message: "Invalid from/to, expected YYYY-MM-DD"},
+			apierror.FieldError{Field: "to", Message: "Invalid from/to, expected YYYY-MM-DD"},
+		))
+		return
+	}
+
+	series, err := h.views.NoteSeries(id, from, to)
+	if err != nil {
+		h.error(w, r, apierror.Internal("Failed to get analytics"))
+		return
+	}
+
+	var total, bots int64
+	for _, d := range series {
+		total += d.Views
+		bots += d.Bots
+	}
+
+	h.respond(w, map[string]interface{}{
+		"note_id": id,
+		"from":    from,
+		"to":      to,
+		"total":   total,
+		"bots":    bots,
+		"days":    series,
+	}, http.StatusOK)
+}
+
+func (h *Handlers) GetTopAnalytics(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsWriter(r) {
+		h.error(w, r, apierror.ErrUnauthorized)
+		return
+	}
+
+	from, to, err := views.ParsePeriod(r.URL.Query().Get("period"))
+	if errors.Is(err, views.ErrRangeTooLong) {
+		h.error(w, r, apierror.Invalid("period", fmt.Sprintf("Period must be at most %d days", views.MaxRangeDays)))
+		return
+	}
+	if err != nil {
+		h.error(w, r, apierror.Invalid("period", "Invalid period, expected e.g. 7d"))
+		return
+	}
+
+	limit := 10
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+
+	report, err := h.views.Top(from, to, limit)
+	if err != nil {
+		h.error(w, r, apierror.Internal("Failed to get analytics"))
+		return
+	}
+
+	h.respond(w, report, http.StatusOK)
+}
+
+func (h *Handlers) GetSourceAnalytics(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsWriter(r) {
+		h.error(w, r, apierror.ErrUnauthorized)
+		return
+	}
+
+	from, to, err := views.ParsePeriod(r.URL.Query().Get("period"))
+	if errors.Is(err, views.ErrRangeTooLong) {
+		h.error(w, r, apierror.Invalid("period", fmt.Sprintf("Period must be at most %d days", views.MaxRangeDays)))
+		return
+	}
+	if err != nil {
+		h.error(w, r, apierror.Invalid("period", "Invalid period, expected e.g. 7d"))
+		return
+	}
+
+	var noteID int64
+	if s := r.URL.Query().Get("note_id"); s != "" {
+		noteID, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			h.error(w, r, apierror.Invalid("note_id", "Invalid note ID"))
+			return
+		}
+	}
+
+	report, err := h.views.Sources(noteID, from, to)
+	if err != nil {
+		h.error(w, r, apierror.Internal("Failed to get analytics"))
+		return
+	}
+
+	h.respond(w, report, http.StatusOK)
+}
+
+// Export
+func (h *Handlers) Export(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsWriter(r) {
+		h.error(w, r, apierror.ErrUnauthorized)
+		return
+	}
+
+	// Build the archive first so a failure can still be reported as an error
+	var buf bytes.Buffer
+	if _, err := archive.Export(h.db, &buf); err != nil {
+		h.error(w, r, apierror.Internal("Failed to export notes"))
+		return
+	}
+
+	filename := "lava-notes-" + time.Now().UTC().Format("20060102") + ".zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Write(buf.Bytes())
+}
+
+// maxImportSize bounds uploaded import archives
+const maxImportSize = 64 << 20
+
+// Import reads a zip archive, either as the raw body or as the "file" field of a multipart form
+func (h *Handlers) Import(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsWriter(r) {
+		h.error(w, r, apierror.ErrUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+	strategy, err := archive.ParseStrategy(q.Get("strategy"))
+	if err != nil {
+		h.error(w, r, apierror.Invalid("strategy", err.Error()))
+		return
+	}
+	dryRun, _ := strconv.ParseBool(q.Get("dry_run"))
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			h.error(w, r, apierror.Invalid("file", "Missing file"))
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		h.error(w, r, apierror.New(http.StatusRequestEntityTooLarge, apierror.CodePayloadTooLarge, "Archive too large or unreadable"))
+		return
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		h.error(w, r, apierror.New(http.StatusBadRequest, apierror.CodeInvalidArchive, "Invalid zip archive"))
+		return
+	}
+
+	report, err := archive.Import(h.db, zr, archive.ImportOptions{
+		Strategy:     strategy,
+		DryRun:       dryRun,
+		RootCategory: q.Get("root_category"),
+	})
+	if err != nil {
+		var invalid *archive.InvalidError
+		if !errors.As(err, &invalid) {
+			log.Printf("Failed to import archive (request %s): %v", apierror.RequestID(r), err)
+			h.error(w, r, apierror.Internal("Failed to import archive"))
+			return
+		}
+		apiErr := apierror.New(http.StatusBadRequest, apierror.CodeInvalidArchive, "Invalid archive entries")
+		for _, p := range invalid.Problems {
+			apiErr.Fields = append(apiErr.Fields, apierror.FieldError{Field: p.Path, Message: p.Message})
+		}
+		h.error(w, r, apiErr)
+		return
+	}
+
+	if !dryRun {
+		h.cache.Clear()
+	}
+	h.respond(w, report, http.StatusOK)
+}
+
+// Admin
+func (h *Handlers) GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsWriter(r) {
+		h.error(w, r, apierror.ErrUnauthorized)
+		return
+	}
+
+	h.respond(w, map[string]interface{}{
+		"stats":       h.cache.Stats(),
+		"ttl_seconds": int64(h.cache.TTL().Seconds()),
+		"top_keys":    h.cache.TopKeys(20),
+	}, http.StatusOK)
+}
+
+func (h *Handlers) ClearCache(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsWriter(r) {
+		h.error(w, r, apierror.ErrUnauthorized)
+		return
+	}
+
+	h.cache.Clear()
+	h.respond(w, nil, http.StatusNoContent)
+}
+
+// Backups and database health
+func (h *Handlers) ListBackups(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsWriter(r) {
+		h.error(w, r, apierror.ErrUnauthorized)
+		return
+	}
+
+	snapshots, err := h.backups.List()
+	if err != nil {
+		h.error(w, r, apierror.Internal("Failed to list backups"))
+		return
+	}
+	h.respond(w, snapshots, http.StatusOK)
+}
+
+func (h *Handlers) CreateBackup(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsWriter(r) {
+		h.error(w, r, apierror.ErrUnauthorized)
+		return
+	}
+
+	snapshot, err := h.backups.Snapshot()
+	if err != nil {
+		h.error(w, r, apierror.Internal("Failed to create backup"))
+		return
+	}
+	h.respond(w, snapshot, http.StatusCreated)
+}
+
+// GetDBHealth reports database integrity, 503 when a problem was found
+func (h *Handlers) GetDBHealth(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsWriter(r) {
+		h.error(w, r, apierror.ErrUnauthorized)
+		return
+	}
+
+	report, err := h.db.Check()
+	if err != nil {
+		h.error(w, r, apierror.Internal("Failed to check database"))
+		return
+	}
+	status := http.StatusOK
+	if !report.OK {
+		status = http.StatusServiceUnavailable
+	}
+	h.respond(w, report, status)
+}
+
 // Search
 func (h *Handlers) SearchNotes(w http.ResponseWriter, r *http.Request) {
 	if !auth.IsWriter(r) {
-		h.error(w, "Unauthorized", http.StatusUnauthorized)
+		h.error(w, r, apierror.ErrUnauthorized)
 		return
 	}
 
 	query := r.URL.Query().Get("q")
 	if len(query) < 3 {
-		h.error(w, "Query must be at least 3 characters", http.StatusBadRequest)
+		h.error(w, r, apierror.Invalid("q", "Query must be at least 3 characters"))
 		return
 	}
 
 	results, err := h.db.SearchNotes(query, true, 5)
 	if err != nil {
-		h.error(w, "Search failed", http.StatusInternalServerError)
+		h.error(w, r, apierror.Internal("Search failed"))
 		return
 	}
 
@@ -441,3 +1279,11 @@ func (h *Handlers) SearchNotes(w http.ResponseWriter, r *http.Request) {
 
 	h.respond(w, results, http.StatusOK)
 }
+
+// API description
+// OpenAPI serves the API description
+func (h *Handlers) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(openapi.Spec)
+}
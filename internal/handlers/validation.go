@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"lava-notes/internal/apierror"
+	"lava-notes/internal/archive"
+	"lava-notes/internal/validate"
+)
+
+const (
+	maxJSONBody = 64 << 10
+	maxNoteBody = 8 << 20 // content is JSON escaped and may be encrypted, which grows it by a third
+)
+
+var (
+	errNoteConflict     = apierror.New(http.StatusConflict, apierror.CodeNameConflict, "A note with this name already exists in the category")
+	errCategoryConflict = apierror.New(http.StatusConflict, apierror.CodeNameConflict, "A category with this name already exists")
+)
+
+// decodeJSON reads a JSON body of at most limit bytes into dst
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}, limit int64) *apierror.Error {
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	if err := json.NewDecoder(r.Body).Decode(dst); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return apierror.New(http.StatusRequestEntityTooLarge, apierror.CodePayloadTooLarge,
+				fmt.Sprintf("Request body exceeds %d bytes", limit))
+		}
+		return apierror.ErrInvalidBody
+	}
+	return nil
+}
+
+// validator collects rejected fields, so a client sees every problem at once
+type validator struct {
+	fields []apierror.FieldError
+}
+
+func (v *validator) add(field, message string) {
+	v.fields = append(v.fields, apierror.FieldError{Field: field, Message: message})
+}
+
+func (v *validator) err() *apierror.Error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return apierror.Validation(v.fields...)
+}
+
+// check records err for field, prefixed with the field name
+func (v *validator) check(field string, err error) {
+	if err != nil {
+		v.add(field, field+" "+err.Error())
+	}
+}
+
+// name checks a note or category name, encrypted names only by size
+func (v *validator) name(field, name string, max int) {
+	if archive.IsEncrypted(name) {
+		v.check(field, validate.EncryptedName(name))
+		return
+	}
+	v.check(field, validate.Name(name, max))
+}
+
+func (v *validator) icon(field, icon string) {
+	v.check(field, validate.Icon(icon))
+}
+
+func (v *validator) content(field, content string) {
+	v.check(field, validate.Content(content))
+}
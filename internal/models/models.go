@@ -10,6 +10,10 @@ type Category struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+func (c Category) Size() int64 {
+	return int64(len(c.Name)+len(c.Icon)) + 64
+}
+
 type Note struct {
 	ID         int64     `json:"id"`
 	CategoryID int64     `json:"category_id"`
@@ -20,22 +24,95 @@ type Note struct {
 	UpdatedAt  time.Time `json:"updated_at"`
 }
 
+// Size approximates the memory used by a note, for byte-bounded caches
+func (n *Note) Size() int64 {
+	return int64(len(n.Name)+len(n.Content)+len(n.Icon)) + 64
+}
+
 type NoteListItem struct {
 	ID         int64     `json:"id"`
 	CategoryID int64     `json:"category_id"`
 	Name       string    `json:"name"`
 	Icon       string    `json:"icon"`
+	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
 }
 
+func (n NoteListItem) Size() int64 {
+	return int64(len(n.Name)+len(n.Icon)) + 72
+}
+
+// NoteQuery selects a page of notes, zero values don't filter
+type NoteQuery struct {
+	CategoryID   int64
+	Sort         string // name, created, updated or views
+	Desc         bool
+	Icon         string
+	Language     string // two-letter name suffix, "en" also matches notes without one
+	UpdatedSince time.Time
+	Public       bool // hide locked notes and notes of locked categories
+	Limit        int  // 0 returns all matching notes
+	Cursor       string
+}
+
 type AuthToken struct {
 	ID        int64     `json:"id"`
 	Token     string    `json:"token"`
 	Used      bool      `json:"used"`
+	MaxUses   int       `json:"max_uses"`
+	Uses      int       `json:"uses"`
+	Revoked   bool      `json:"revoked"`
 	CreatedAt time.Time `json:"created_at"`
 	ExpiresAt time.Time `json:"expires_at"`
 }
 
+type TOTP struct {
+	Secret    string    `json:"-"`
+	Enabled   bool      `json:"enabled"`
+	LastStep  int64     `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebAuthnCredential struct {
+	ID           int64      `json:"id"`
+	CredentialID string     `json:"credential_id"`
+	PublicKey    []byte     `json:"-"`
+	SignCount    uint32     `json:"sign_count"`
+	Name         string     `json:"name"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+}
+
+type DailyViews struct {
+	Day   string `json:"day"`
+	Views int64  `json:"views"`
+	Bots  int64  `json:"bots"`
+}
+
+type NoteViewTotal struct {
+	NoteID       int64  `json:"note_id"`
+	Name         string `json:"name"`
+	Icon         string `json:"icon"`
+	CategoryID   int64  `json:"category_id"`
+	CategoryName string `json:"category_name"`
+	Views        int64  `json:"views"`
+}
+
+// ViewSource identifies where views of a note came from on a given day
+type ViewSource struct {
+	NoteID   int64  `json:"note_id,omitempty"`
+	Day      string `json:"day,omitempty"`
+	Referrer string `json:"referrer"`
+	Source   string `json:"utm_source"`
+	Medium   string `json:"utm_medium"`
+	Campaign string `json:"utm_campaign"`
+}
+
+type SourceTotal struct {
+	ViewSource
+	Views int64 `json:"views"`
+}
+
 type SearchResult struct {
 	ID           int64  `json:"id"`
 	CategoryID   int64  `json:"category_id"`
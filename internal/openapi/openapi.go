@@ -0,0 +1,7 @@
+// Package openapi holds the OpenAPI 3 document of the JSON API, update it with the routes in cmd/server
+package openapi
+
+import _ "embed"
+
+//go:embed openapi.json
+var Spec []byte
@@ -0,0 +1,252 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"lava-notes/internal/apierror"
+)
+
+// idleTimeout is how long an untouched bucket or failure record is kept
+const idleTimeout = 30 * time.Minute
+
+// ClientIP resolves the client address using the IP_HEADER convention:
+// the configured header when set (e.g. CF-Connecting-IP behind a proxy),
+// otherwise the connection's remote address
+func ClientIP(r *http.Request, ipHeader string) string {
+	if ipHeader != "" {
+		if ip := strings.TrimSpace(r.Header.Get(ipHeader)); ip != "" {
+			// X-Forwarded-For style headers may carry a list. Earlier entries come from
+			// the client and can be forged, the last one was added by the proxy.
+			if i := strings.LastIndexByte(ip, ','); i >= 0 {
+				ip = strings.TrimSpace(ip[i+1:])
+			}
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a token bucket rate limiter keyed by client IP
+type Limiter struct {
+	mu       sync.Mutex
+	rate     float64 // tokens per second
+	burst    float64
+	buckets  map[string]*bucket
+	ipHeader string
+}
+
+// New creates a limiter allowing perMinute requests on average with bursts up to burst
+func New(perMinute, burst int, ipHeader string) *Limiter {
+	l := &Limiter{
+		rate:     float64(perMinute) / 60,
+		burst:    float64(burst),
+		buckets:  make(map[string]*bucket),
+		ipHeader: ipHeader,
+	}
+
+	// Start cleanup goroutine
+	go l.cleanupLoop()
+
+	return l
+}
+
+// Allow takes a token for key, returns false and the wait time when empty
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// Middleware rejects requests over the limit with 429.
+// When methods are given, only requests with those methods are counted.
+func (l *Limiter) Middleware(next http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(methods) > 0 && !contains(methods, r.Method) {
+			next(w, r)
+			return
+		}
+		if ok, wait := l.Allow(ClientIP(r, l.ipHeader)); !ok {
+			tooManyRequests(w, r, wait)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (l *Limiter) cleanupLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		l.mu.Lock()
+		cutoff := time.Now().Add(-idleTimeout)
+		for key, b := range l.buckets {
+			if b.last.Before(cutoff) {
+				delete(l.buckets, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+type failure struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// Lockout temporarily blocks clients after repeated failed logins
+type Lockout struct {
+	mu        sync.Mutex
+	threshold int
+	duration  time.Duration
+	failures  map[string]*failure
+	ipHeader  string
+}
+
+// NewLockout blocks a client for duration after threshold consecutive failures
+func NewLockout(threshold int, duration time.Duration, ipHeader string) *Lockout {
+	lo := &Lockout{
+		threshold: threshold,
+		duration:  duration,
+		failures:  make(map[string]*failure),
+		ipHeader:  ipHeader,
+	}
+
+	// Start cleanup goroutine
+	go lo.cleanupLoop()
+
+	return lo
+}
+
+// Locked reports whether key is locked out and for how long
+func (lo *Lockout) Locked(key string) (bool, time.Duration) {
+	lo.mu.Lock()
+	defer lo.mu.Unlock()
+
+	if f, ok := lo.failures[key]; ok {
+		if wait := time.Until(f.lockedUntil); wait > 0 {
+			return true, wait
+		}
+	}
+	return false, 0
+}
+
+// Fail records a failed attempt, locking key out once the threshold is reached
+func (lo *Lockout) Fail(key string) {
+	lo.mu.Lock()
+	defer lo.mu.Unlock()
+
+	f, ok := lo.failures[key]
+	if !ok {
+		f = &failure{}
+		lo.failures[key] = f
+	}
+	f.count++
+	f.last = time.Now()
+	if f.count >= lo.threshold {
+		f.lockedUntil = f.last.Add(lo.duration)
+		f.count = 0
+	}
+}
+
+// Success clears failures for key
+func (lo *Lockout) Success(key string) {
+	lo.mu.Lock()
+	defer lo.mu.Unlock()
+	delete(lo.failures, key)
+}
+
+// Middleware blocks locked-out clients and counts 401 responses as failures.
+// Failures are only cleared by a response that issues a session cookie, so
+// intermediate pages such as the second factor form don't reset the count.
+func (lo *Lockout) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := ClientIP(r, lo.ipHeader)
+		if locked, wait := lo.Locked(key); locked {
+			tooManyRequests(w, r, wait)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		switch {
+		case rec.status == http.StatusUnauthorized:
+			lo.Fail(key)
+		case rec.status < 400 && len(w.Header().Values("Set-Cookie")) > 0:
+			lo.Success(key)
+		}
+	}
+}
+
+func (lo *Lockout) cleanupLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		lo.mu.Lock()
+		now := time.Now()
+		for key, f := range lo.failures {
+			if now.After(f.lockedUntil) && now.Sub(f.last) > idleTimeout {
+				delete(lo.failures, key)
+			}
+		}
+		lo.mu.Unlock()
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func tooManyRequests(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+	apierror.Write(w, r, apierror.ErrRateLimited)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name, header, value, remote, want string
+	}{
+		{"remote address", "", "", "192.0.2.1:1234", "192.0.2.1"},
+		{"header not configured", "", "203.0.113.9", "192.0.2.1:1234", "192.0.2.1"},
+		{"configured header", "X-Real-IP", "203.0.113.9", "192.0.2.1:1234", "203.0.113.9"},
+		{"header missing", "X-Real-IP", "", "192.0.2.1:1234", "192.0.2.1"},
+		{"right-most forwarded entry", "X-Forwarded-For", "6.6.6.6, 198.51.100.7,  203.0.113.9 ", "192.0.2.1:1234", "203.0.113.9"},
+		{"single forwarded entry", "X-Forwarded-For", "203.0.113.9", "192.0.2.1:1234", "203.0.113.9"},
+		{"remote address without port", "", "", "192.0.2.1", "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.value != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+		if got := ClientIP(r, tt.header); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLimiterRefill(t *testing.T) {
+	l := New(60, 2, "") // one token per second
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d of the burst was limited", i+1)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok {
+		t.Fatal("request over the burst was allowed")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Fatalf("wait %s, want up to a second", wait)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Fatal("another client was limited")
+	}
+
+	// A second later one token is back, but not two
+	l.mu.Lock()
+	l.buckets["a"].last = l.buckets["a"].last.Add(-time.Second)
+	l.mu.Unlock()
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("no token after a second")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Fatal("refill went past the rate")
+	}
+
+	// The bucket never holds more than the burst
+	l.mu.Lock()
+	l.buckets["a"].last = l.buckets["a"].last.Add(-time.Hour)
+	l.mu.Unlock()
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("a")
+		if ok != (i < 2) {
+			t.Fatalf("request %d after an hour: allowed %v", i+1, ok)
+		}
+	}
+}
+
+func TestLimiterMiddleware(t *testing.T) {
+	l := New(30, 1, "X-Real-IP") // one token every two seconds
+	h := l.Middleware(func(w http.ResponseWriter, r *http.Request) {}, http.MethodPost)
+	send := func(method string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/", nil)
+		r.Header.Set("X-Real-IP", "203.0.113.9")
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	if w := send(http.MethodPost); w.Code != http.StatusOK {
+		t.Fatalf("first request: %d", w.Code)
+	}
+	w := send(http.MethodPost)
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), `"code":"rate_limited"`) {
+		t.Fatalf("second request: %d %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("Retry-After %q, want 2", got)
+	}
+	if w := send(http.MethodGet); w.Code != http.StatusOK {
+		t.Fatalf("method that isn't counted was limited: %d", w.Code)
+	}
+}
+
+// login answers like the login handler: 401 for a wrong code, the second factor
+// form without a session, or a redirect that issues the session cookie
+func login(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Query().Get("code") {
+	case "":
+		w.Write([]byte("<form>"))
+	case "123456":
+		http.SetCookie(w, &http.Cookie{Name: "lava_token", Value: "jwt"})
+		http.Redirect(w, r, "/", http.StatusFound)
+	default:
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	lo := NewLockout(3, time.Minute, "X-Real-IP")
+	h := lo.Middleware(login)
+	send := func(ip, code string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/auth/login?code="+code, nil)
+		r.Header.Set("X-Real-IP", ip)
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	// Showing the second factor form between wrong codes doesn't reset the count
+	for i := 0; i < 3; i++ {
+		if w := send("203.0.113.9", "000000"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: %d", i+1, w.Code)
+		}
+		if i < 2 {
+			if w := send("203.0.113.9", ""); w.Code != http.StatusOK {
+				t.Fatalf("form %d: %d", i+1, w.Code)
+			}
+		}
+	}
+	w := send("203.0.113.9", "123456")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("locked out client got %d", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Fatalf("Retry-After %q, want 60", got)
+	}
+	if w := send("198.51.100.7", "000000"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("another client got %d", w.Code)
+	}
+
+	// The lockout expires
+	lo.mu.Lock()
+	lo.failures["203.0.113.9"].lockedUntil = time.Now().Add(-time.Second)
+	lo.mu.Unlock()
+	if w := send("203.0.113.9", "123456"); w.Code != http.StatusFound {
+		t.Fatalf("after the lockout: %d", w.Code)
+	}
+
+	// Only an issued session clears failures
+	send("198.51.100.7", "000000")
+	if w := send("198.51.100.7", "123456"); w.Code != http.StatusFound {
+		t.Fatalf("login: %d", w.Code)
+	}
+	for i := 0; i < 2; i++ {
+		send("198.51.100.7", "000000")
+	}
+	if locked, _ := lo.Locked("198.51.100.7"); locked {
+		t.Fatal("failures before a successful login were still counted")
+	}
+}
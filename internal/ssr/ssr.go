@@ -1,6 +1,7 @@
 package ssr
 
 import (
+	"errors"
 	"html"
 	"net/http"
 	"os"
@@ -8,20 +9,28 @@ import (
 	"strconv"
 	"strings"
 
+	"lava-notes/internal/cache"
 	"lava-notes/internal/db"
+	"lava-notes/internal/views"
 )
 
 var noteURLPattern = regexp.MustCompile(`/note/(\d+)`)
 
+var errNotRendered = errors.New("note can't be rendered")
+
 type SSR struct {
 	db           *db.DB
+	cache        *cache.Cache[string, any]
+	views        *views.Views
 	templatePath string
 	template     string
 }
 
-func New(database *db.DB, templatePath string) *SSR {
+func New(database *db.DB, c *cache.Cache[string, any], v *views.Views, templatePath string) *SSR {
 	return &SSR{
 		db:           database,
+		cache:        c,
+		views:        v,
 		templatePath: templatePath,
 	}
 }
@@ -206,19 +215,47 @@ func (s *SSR) ServeHTTP(w http.ResponseWriter, r *http.Request) bool {
 		return false
 	}
 
-	note, err := s.db.GetNote(noteID)
+	// Pages are rendered once and shared by concurrent requests, an expired
+	// page is served while a fresh one renders in the background
+	page, err := s.cache.GetOrLoadStale(cache.SSRKey(noteID), func() (any, []string, error) {
+		return s.render(noteID)
+	})
 	if err != nil {
 		return false
 	}
 
+	// Crawlers rarely run the SPA, so the page view is recorded here.
+	// A browser's follow-up API request is the same visitor and isn't counted twice.
+	s.views.RecordView(noteID, s.views.VisitFromRequest(r))
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page.(string)))
+	return true
+}
+
+// Warm renders a note page into the cache ahead of the first request
+func (s *SSR) Warm(noteID int64) error {
+	_, err := s.cache.GetOrLoad(cache.SSRKey(noteID), func() (any, []string, error) {
+		return s.render(noteID)
+	})
+	return err
+}
+
+// render builds the full page for a note, with tags for cache invalidation
+func (s *SSR) render(noteID int64) (string, []string, error) {
+	note, err := s.db.GetNote(noteID)
+	if err != nil {
+		return "", nil, err
+	}
+
 	// Don't SSR locked notes
 	if note.Icon == "lock" {
-		return false
+		return "", nil, errNotRendered
 	}
 
 	template := s.loadTemplate()
 	if template == "" {
-		return false
+		return "", nil, errNotRendered
 	}
 
 	// Render markdown to HTML for SEO
@@ -228,8 +265,5 @@ func (s *SSR) ServeHTTP(w http.ResponseWriter, r *http.Request) bool {
 
 	// Replace placeholder
 	output := strings.Replace(template, "__SSR_CONTENT__", ssrContent, 1)
-
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Write([]byte(output))
-	return true
+	return output, []string{cache.NoteTag(note.ID), cache.CategoryTag(note.CategoryID)}, nil
 }
@@ -0,0 +1,78 @@
+// Package validate holds the rules for names, icons and contents shared by the API and imports.
+// Errors describe the problem without the subject, e.g. "is required", so callers can prefix a field or file.
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MaxNameLength          = 200 // characters
+	MaxCategoryNameLength  = 100
+	MaxEncryptedNameLength = 2048 // bytes, the ciphertext of a maximal name
+	MaxContentLength       = 5 << 20
+	MaxIconLength          = 64
+)
+
+// Icons come from the light-icons and devicon sets, which the browser loads from a CDN,
+// so names are checked against the naming scheme of each set
+var (
+	lightIconRe = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	deviconRe   = regexp.MustCompile(`^devicon-[a-z0-9]+(-[a-z0-9]+)*-(plain|original|line)(-wordmark)?$`)
+)
+
+// Name checks a plain note or category name of at most max characters. Names become file
+// names in exports and targets of [[Category/Note]] links, so path separators and control
+// characters are rejected.
+func Name(name string, max int) error {
+	switch {
+	case strings.TrimSpace(name) == "":
+		return errors.New("is required")
+	case !utf8.ValidString(name):
+		return errors.New("must be valid UTF-8")
+	case utf8.RuneCountInString(name) > max:
+		return fmt.Errorf("must be at most %d characters", max)
+	case strings.TrimSpace(name) != name:
+		return errors.New("must not start or end with whitespace")
+	case name == "." || name == "..":
+		return errors.New("must not be . or ..")
+	case strings.IndexFunc(name, invalidNameRune) >= 0:
+		return errors.New(`must not contain /, \ or control characters`)
+	}
+	return nil
+}
+
+func invalidNameRune(r rune) bool {
+	return r == '/' || r == '\\' || unicode.IsControl(r)
+}
+
+// EncryptedName checks a name encrypted in the browser, which is base64 so only its size can be checked
+func EncryptedName(name string) error {
+	if len(name) > MaxEncryptedNameLength {
+		return fmt.Errorf("must be at most %d bytes", MaxEncryptedNameLength)
+	}
+	return nil
+}
+
+// Icon checks an icon name, empty means the default icon of a category or note
+func Icon(icon string) error {
+	if icon == "" {
+		return nil
+	}
+	if len(icon) > MaxIconLength || !(lightIconRe.MatchString(icon) || deviconRe.MatchString(icon)) {
+		return errors.New("must be a light-icons or devicon name")
+	}
+	return nil
+}
+
+func Content(content string) error {
+	if len(content) > MaxContentLength {
+		return fmt.Errorf("must be at most %d bytes", MaxContentLength)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package views
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"lava-notes/internal/models"
+)
+
+var (
+	ErrInvalidRange = errors.New("invalid date range")
+	ErrRangeTooLong = errors.New("date range too long")
+)
+
+// MaxRangeDays bounds the days of a range, a series has one entry per day
+const MaxRangeDays = 366
+
+// langSuffixPattern matches the __xx language suffix of note names (see static/main.js)
+var langSuffixPattern = regexp.MustCompile(`(?i)__([a-z]{2})(?:\.md)?$`)
+
+// Language returns the language code of a note name, or "" for the default variant
+func Language(name string) string {
+	if m := langSuffixPattern.FindStringSubmatch(name); m != nil {
+		return strings.ToLower(m[1])
+	}
+	return ""
+}
+
+// ParseRange parses from/to days (YYYY-MM-DD), defaulting to the last 30 days
+func ParseRange(from, to string) (string, string, error) {
+	today := time.Now().UTC()
+	if to == "" {
+		to = today.Format(time.DateOnly)
+	}
+	if from == "" {
+		from = today.AddDate(0, 0, -29).Format(time.DateOnly)
+	}
+	f, err := time.Parse(time.DateOnly, from)
+	if err != nil {
+		return "", "", ErrInvalidRange
+	}
+	t, err := time.Parse(time.DateOnly, to)
+	if err != nil || t.Before(f) {
+		return "", "", ErrInvalidRange
+	}
+	if t.Sub(f) >= MaxRangeDays*24*time.Hour {
+		return "", "", ErrRangeTooLong
+	}
+	return from, to, nil
+}
+
+// ParsePeriod converts a period like "7d" or "24h" into a from/to day range ending today
+func ParsePeriod(period string) (string, string, error) {
+	if period == "" {
+		period = "7d"
+	}
+	var days int
+	if n, ok := strings.CutSuffix(period, "d"); ok {
+		v, err := strconv.Atoi(n)
+		if err != nil || v < 1 {
+			return "", "", ErrInvalidRange
+		}
+		days = v
+	} else {
+		d, err := time.ParseDuration(period)
+		if err != nil || d <= 0 {
+			return "", "", ErrInvalidRange
+		}
+		days = int((d + 24*time.Hour - 1) / (24 * time.Hour))
+	}
+	if days > MaxRangeDays {
+		return "", "", ErrRangeTooLong
+	}
+	today := time.Now().UTC()
+	return today.AddDate(0, 0, 1-days).Format(time.DateOnly), today.Format(time.DateOnly), nil
+}
+
+// NoteSeries returns daily views of a note, including days without views
+func (v *Views) NoteSeries(noteID int64, from, to string) ([]models.DailyViews, error) {
+	if err := v.flush(); err != nil {
+		return nil, err
+	}
+	stored, err := v.db.GetNoteDailyViews(noteID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	byDay := make(map[string]models.DailyViews, len(stored))
+	for _, dv := range stored {
+		byDay[dv.Day] = dv
+	}
+	start, _ := time.Parse(time.DateOnly, from)
+	end, _ := time.Parse(time.DateOnly, to)
+	series := make([]models.DailyViews, 0)
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		day := d.Format(time.DateOnly)
+		series = append(series, models.DailyViews{Day: day, Views: byDay[day].Views, Bots: byDay[day].Bots})
+	}
+	return series, nil
+}
+
+type CategoryTotal struct {
+	CategoryID   int64  `json:"category_id"`
+	CategoryName string `json:"category_name"`
+	Views        int64  `json:"views"`
+}
+
+type LanguageTotal struct {
+	Language string `json:"language"` // "" is the default variant without a suffix
+	Views    int64  `json:"views"`
+}
+
+type TopReport struct {
+	From       string                 `json:"from"`
+	To         string                 `json:"to"`
+	Total      int64                  `json:"total"`
+	Notes      []models.NoteViewTotal `json:"notes"`
+	Categories []CategoryTotal        `json:"categories"`
+	Languages  []LanguageTotal        `json:"languages"`
+}
+
+// Top reports the most viewed notes with totals per category and language variant
+func (v *Views) Top(from, to string, limit int) (*TopReport, error) {
+	if err := v.flush(); err != nil {
+		return nil, err
+	}
+	totals, err := v.db.GetViewTotals(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &TopReport{From: from, To: to}
+	categories := make(map[int64]*CategoryTotal)
+	languages := make(map[string]int64)
+	for _, t := range totals {
+		report.Total += t.Views
+		ct, ok := categories[t.CategoryID]
+		if !ok {
+			ct = &CategoryTotal{CategoryID: t.CategoryID, CategoryName: t.CategoryName}
+			categories[t.CategoryID] = ct
+		}
+		ct.Views += t.Views
+		// Encrypted names carry no readable suffix and count as default
+		languages[Language(t.Name)] += t.Views
+	}
+
+	if len(totals) > limit {
+		totals = totals[:limit]
+	}
+	report.Notes = append([]models.NoteViewTotal{}, totals...)
+
+	report.Categories = make([]CategoryTotal, 0, len(categories))
+	for _, ct := range categories {
+		report.Categories = append(report.Categories, *ct)
+	}
+	sort.Slice(report.Categories, func(i, j int) bool {
+		return report.Categories[i].Views > report.Categories[j].Views
+	})
+
+	report.Languages = make([]LanguageTotal, 0, len(languages))
+	for lang, n := range languages {
+		report.Languages = append(report.Languages, LanguageTotal{Language: lang, Views: n})
+	}
+	sort.Slice(report.Languages, func(i, j int) bool {
+		return report.Languages[i].Views > report.Languages[j].Views
+	})
+
+	return report, nil
+}
+
+// SourcesReport breaks views down by referrer host and utm campaign
+type SourcesReport struct {
+	From      string               `json:"from"`
+	To        string               `json:"to"`
+	NoteID    int64                `json:"note_id,omitempty"`
+	Referrers []SourceCount        `json:"referrers"`
+	Campaigns []SourceCount        `json:"campaigns"`
+	Sources   []models.SourceTotal `json:"sources"`
+}
+
+type SourceCount struct {
+	Name  string `json:"name"`
+	Views int64  `json:"views"`
+}
+
+// Sources aggregates referrers and campaigns of one note, or all notes when noteID is 0
+func (v *Views) Sources(noteID int64, from, to string) (*SourcesReport, error) {
+	if err := v.flush(); err != nil {
+		return nil, err
+	}
+	totals, err := v.db.GetSourceTotals(noteID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	referrers := make(map[string]int64)
+	campaigns := make(map[string]int64)
+	for _, t := range totals {
+		if t.Referrer != "" {
+			referrers[t.Referrer] += t.Views
+		}
+		if t.Source != "" || t.Campaign != "" {
+			name := t.Source
+			if t.Campaign != "" {
+				name += "/" + t.Campaign
+			}
+			campaigns[name] += t.Views
+		}
+	}
+
+	if totals == nil {
+		totals = []models.SourceTotal{}
+	}
+	return &SourcesReport{
+		From:      from,
+		To:        to,
+		NoteID:    noteID,
+		Referrers: sortedCounts(referrers),
+		Campaigns: sortedCounts(campaigns),
+		Sources:   totals,
+	}, nil
+}
+
+func sortedCounts(m map[string]int64) []SourceCount {
+	counts := make([]SourceCount, 0, len(m))
+	for name, n := range m {
+		counts = append(counts, SourceCount{Name: name, Views: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Views != counts[j].Views {
+			return counts[i].Views > counts[j].Views
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	return counts
+}
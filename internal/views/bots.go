@@ -0,0 +1,87 @@
+package views
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// botPatterns are lowercase user agent substrings of crawlers, previewers and
+// HTTP libraries. Extra patterns can be added with BOT_PATTERNS (comma separated).
+var botPatterns = []string{
+	// Generic markers used by most well-behaved crawlers
+	"bot", "crawl", "spider", "slurp", "archiver", "indexer", "scanner", "monitor",
+	// Search engines and SEO tools without a generic marker
+	"mediapartners-google", "google-inspectiontool", "bingpreview", "yandex", "baiduspider",
+	"sogou", "exabot", "ia_archiver", "ahrefs", "semrush", "mj12", "dotbot", "petalbot",
+	// Link previews
+	"facebookexternalhit", "facebookcatalog", "whatsapp", "telegram", "skypeuripreview",
+	"embedly", "quora link preview", "vkshare", "pinterest", "redditbot",
+	// Headless browsers and HTTP clients
+	"headless", "phantomjs", "puppeteer", "playwright", "selenium", "lighthouse",
+	"curl", "wget", "python-requests", "python-urllib", "aiohttp", "httpx", "go-http-client",
+	"java/", "okhttp", "libwww-perl", "node-fetch", "axios", "postman", "insomnia",
+	// Feed readers and uptime checks
+	"feedfetcher", "feedly", "uptime", "pingdom", "statuscake",
+}
+
+// Behaviour limits: a visitor reading more notes than this is treated as a crawler
+const (
+	botBurstWindow = time.Minute
+	botBurstNotes  = 20  // distinct notes within botBurstWindow
+	botDailyNotes  = 150 // distinct notes within a salt period (one day)
+)
+
+// visitor tracks how many distinct notes a visitor viewed in the current salt period
+type visitor struct {
+	notes        int
+	burstStart   time.Time
+	burstNotes   int
+	flaggedAsBot bool
+}
+
+func loadBotPatterns() []string {
+	patterns := append([]string{}, botPatterns...)
+	for _, p := range strings.Split(os.Getenv("BOT_PATTERNS"), ",") {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// isBotAgent reports whether a user agent belongs to a known bot.
+// An empty user agent is never sent by browsers.
+func (v *Views) isBotAgent(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	if ua == "" {
+		return true
+	}
+	for _, p := range v.botPatterns {
+		if strings.Contains(ua, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// seenNote records a new note view of a visitor and reports whether its
+// behaviour looks automated. Once flagged, a visitor stays a bot for the day.
+// Caller must hold the lock.
+func (v *Views) seenNote(visitorKey uint64, now time.Time) bool {
+	vis := v.visitors[visitorKey]
+	if vis == nil {
+		vis = &visitor{burstStart: now}
+		v.visitors[visitorKey] = vis
+	}
+	if now.Sub(vis.burstStart) > botBurstWindow {
+		vis.burstStart = now
+		vis.burstNotes = 0
+	}
+	vis.notes++
+	vis.burstNotes++
+	if vis.burstNotes > botBurstNotes || vis.notes > botDailyNotes {
+		vis.flaggedAsBot = true
+	}
+	return vis.flaggedAsBot
+}
@@ -0,0 +1,81 @@
+package views
+
+import "lava-notes/internal/models"
+
+// ViewDeltas are views recorded since the last flush to the database
+type ViewDeltas struct {
+	Views     map[int64]int64            // noteID -> views
+	Bots      map[int64]int64            // noteID -> bot views
+	Daily     map[int64]map[string]int64 // noteID -> UTC day -> views
+	DailyBots map[int64]map[string]int64 // noteID -> UTC day -> bot views
+	Sources   map[models.ViewSource]int64
+}
+
+func NewViewDeltas() ViewDeltas {
+	return ViewDeltas{
+		Views:     make(map[int64]int64),
+		Bots:      make(map[int64]int64),
+		Daily:     make(map[int64]map[string]int64),
+		DailyBots: make(map[int64]map[string]int64),
+		Sources:   make(map[models.ViewSource]int64),
+	}
+}
+
+func (d ViewDeltas) Empty() bool {
+	return len(d.Views) == 0 && len(d.Bots) == 0 && len(d.Daily) == 0 && len(d.DailyBots) == 0 && len(d.Sources) == 0
+}
+
+// Add records n views of a note on a day, sources are added separately
+func (d ViewDeltas) Add(noteID int64, day string, n int64, bot bool) {
+	counts, daily := d.Views, d.Daily
+	if bot {
+		counts, daily = d.Bots, d.DailyBots
+	}
+	counts[noteID] += n
+	if daily[noteID] == nil {
+		daily[noteID] = make(map[string]int64)
+	}
+	daily[noteID][day] += n
+}
+
+// Merge adds other into d, used to requeue deltas after a failed flush
+func (d ViewDeltas) Merge(other ViewDeltas) {
+	for noteID, n := range other.Views {
+		d.Views[noteID] += n
+	}
+	for noteID, n := range other.Bots {
+		d.Bots[noteID] += n
+	}
+	for noteID, days := range other.Daily {
+		for day, n := range days {
+			if d.Daily[noteID] == nil {
+				d.Daily[noteID] = make(map[string]int64)
+			}
+			d.Daily[noteID][day] += n
+		}
+	}
+	for noteID, days := range other.DailyBots {
+		for day, n := range days {
+			if d.DailyBots[noteID] == nil {
+				d.DailyBots[noteID] = make(map[string]int64)
+			}
+			d.DailyBots[noteID][day] += n
+		}
+	}
+	for src, n := range other.Sources {
+		d.Sources[src] += n
+	}
+}
+
+// Forget drops all deltas of a note
+func (d ViewDeltas) Forget(noteID int64) {
+	delete(d.Views, noteID)
+	delete(d.Bots, noteID)
+	delete(d.Daily, noteID)
+	delete(d.DailyBots, noteID)
+	for src := range d.Sources {
+		if src.NoteID == noteID {
+			delete(d.Sources, src)
+		}
+	}
+}
@@ -1,29 +1,63 @@
 package views
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
+	"log"
 	"net"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 
 	"lava-notes/internal/db"
+	"lava-notes/internal/models"
 )
 
+// maxSeen bounds the number of remembered visitors per salt period,
+// views of new visitors are not counted once it is reached
+const maxSeen = 200000
+
+// defaultFlushInterval bounds how many views a crash can lose, override with VIEWS_FLUSH_INTERVAL
+const defaultFlushInterval = time.Minute
+
 type Views struct {
-	mu          sync.RWMutex
-	counts      map[int64]int64            // noteID -> view count
-	seenIPs     map[int64]map[uint32]struct{} // noteID -> set of IPv4 addresses
-	db          *db.DB
-	ipHeaderName string
+	mu            sync.RWMutex
+	flushMu       sync.Mutex          // serializes flushes with deletions
+	counts        map[int64]int64     // noteID -> view count
+	bots          map[int64]int64     // noteID -> bot view count, kept out of counts
+	pending       ViewDeltas          // views not yet written to the database
+	seen          map[uint64]struct{} // hashed (salt, visitor, note) seen in the current period
+	visitors      map[uint64]*visitor // hashed (salt, visitor) -> behaviour in the current period
+	botPatterns   []string
+	salt          []byte
+	saltDay       string // UTC date the salt belongs to
+	ipv6Prefix    int    // IPv6 addresses are truncated to this many bits
+	flushInterval time.Duration
+	db            *db.DB
+	ipHeaderName  string
 }
 
 func New(database *db.DB) *Views {
 	v := &Views{
-		counts:      make(map[int64]int64),
-		seenIPs:     make(map[int64]map[uint32]struct{}),
-		db:          database,
-		ipHeaderName: os.Getenv("IP_HEADER"),
+		counts:        make(map[int64]int64),
+		bots:          make(map[int64]int64),
+		pending:       NewViewDeltas(),
+		seen:          make(map[uint64]struct{}),
+		visitors:      make(map[uint64]*visitor),
+		botPatterns:   loadBotPatterns(),
+		ipv6Prefix:    64,
+		flushInterval: defaultFlushInterval,
+		db:            database,
+		ipHeaderName:  os.Getenv("IP_HEADER"),
+	}
+	// VIEWS_IPV6_PREFIX=128 distinguishes every IPv6 address instead of /64 networks
+	if bits, err := strconv.Atoi(os.Getenv("VIEWS_IPV6_PREFIX")); err == nil && bits > 0 && bits <= 128 {
+		v.ipv6Prefix = bits
+	}
+	if d, err := time.ParseDuration(os.Getenv("VIEWS_FLUSH_INTERVAL")); err == nil && d > 0 {
+		v.flushInterval = d
 	}
 
 	// Load existing views from database
@@ -36,7 +70,14 @@ func New(database *db.DB) *Views {
 }
 
 func (v *Views) loadFromDB() {
-	viewsData, err := v.db.GetAllViews()
+	// Views of notes deleted before they were cleaned up on delete
+	if n, err := v.db.DeleteOrphanViews(); err != nil {
+		log.Printf("Failed to clean up views of deleted notes: %v", err)
+	} else if n > 0 {
+		log.Printf("Cleaned up views of %d deleted notes", n)
+	}
+
+	viewsData, botsData, err := v.db.GetAllViews()
 	if err != nil {
 		return
 	}
@@ -45,10 +86,13 @@ func (v *Views) loadFromDB() {
 	for noteID, count := range viewsData {
 		v.counts[noteID] = count
 	}
+	for noteID, count := range botsData {
+		v.bots[noteID] = count
+	}
 }
 
 func (v *Views) persistLoop() {
-	ticker := time.NewTicker(30 * time.Minute)
+	ticker := time.NewTicker(v.flushInterval)
 	defer ticker.Stop()
 
 	for range ticker.C {
@@ -57,60 +101,157 @@ func (v *Views) persistLoop() {
 }
 
 func (v *Views) persist() {
-	v.mu.RLock()
-	countsCopy := make(map[int64]int64, len(v.counts))
-	for k, val := range v.counts {
-		countsCopy[k] = val
+	if err := v.flush(); err != nil {
+		log.Printf("Failed to persist views: %v", err)
+	}
+}
+
+// Flush writes pending views, so database queries ordered by views see them
+func (v *Views) Flush() error {
+	return v.flush()
+}
+
+// flush writes views recorded since the last flush. Only changed notes are
+// written, as increments, so concurrent flushes or a crash can't undo counts.
+func (v *Views) flush() error {
+	v.flushMu.Lock()
+	defer v.flushMu.Unlock()
+
+	v.mu.Lock()
+	pending := v.pending
+	v.pending = NewViewDeltas()
+	v.mu.Unlock()
+
+	if pending.Empty() {
+		return nil
+	}
+	if err := v.db.ApplyViewDeltas(pending.Views, pending.Bots, pending.Daily, pending.DailyBots, pending.Sources); err != nil {
+		// Put the deltas back so the next flush retries them
+		v.mu.Lock()
+		v.pending.Merge(pending)
+		v.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Forget drops the views of deleted notes from memory and the database
+func (v *Views) Forget(noteIDs ...int64) {
+	if len(noteIDs) == 0 {
+		return
 	}
-	v.mu.RUnlock()
+	v.flushMu.Lock()
+	defer v.flushMu.Unlock()
 
-	// Silently persist without logging
-	v.db.SaveViews(countsCopy)
+	v.mu.Lock()
+	for _, id := range noteIDs {
+		delete(v.counts, id)
+		delete(v.bots, id)
+		v.pending.Forget(id)
+	}
+	v.mu.Unlock()
+
+	if err := v.db.DeleteViews(noteIDs); err != nil {
+		log.Printf("Failed to delete views: %v", err)
+	}
 }
 
-// ipToUint32 converts IPv4 to uint32, returns 0 and false for IPv6
-func ipToUint32(ipStr string) (uint32, bool) {
+// normalizeIP returns the address bytes used to identify a visitor,
+// IPv6 addresses are truncated to their network prefix
+func (v *Views) normalizeIP(ipStr string) (net.IP, bool) {
 	ip := net.ParseIP(ipStr)
 	if ip == nil {
-		return 0, false
+		return nil, false
 	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4, true
+	}
+	return ip.Mask(net.CIDRMask(v.ipv6Prefix, 128)), true
+}
 
-	// Check for IPv4
-	ip4 := ip.To4()
-	if ip4 == nil {
-		return 0, false // IPv6, ignore
+// rotateSalt replaces the salt once a day, forgetting every visitor seen so far.
+// Hashes from different days can't be linked, so uniqueness is per note per day.
+// Caller must hold the lock.
+func (v *Views) rotateSalt(now time.Time) {
+	day := now.UTC().Format(time.DateOnly)
+	if day == v.saltDay {
+		return
+	}
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return
 	}
+	v.salt = salt
+	v.saltDay = day
+	v.seen = make(map[uint64]struct{})
+	v.visitors = make(map[uint64]*visitor)
+}
 
-	return binary.BigEndian.Uint32(ip4), true
+// visitorHash identifies a visitor of a note without keeping the IP or user agent
+func (v *Views) visitorHash(noteID int64, ip net.IP, userAgent string) uint64 {
+	h := sha256.New()
+	h.Write(v.salt)
+	h.Write(ip)
+	h.Write([]byte{0})
+	h.Write([]byte(userAgent))
+	var id [8]byte
+	binary.BigEndian.PutUint64(id[:], uint64(noteID))
+	h.Write(id[:])
+	return binary.BigEndian.Uint64(h.Sum(nil))
 }
 
-// RecordView records a view for a note, deduplicating by IP
-func (v *Views) RecordView(noteID int64, ipHeader string) {
-	if v.ipHeaderName == "" || ipHeader == "" {
+// RecordView records a view for a note, counting each visitor once per day.
+// Visitors are identified by a salted hash of IP (IPv4 or IPv6 prefix) and user agent.
+// Bots, by user agent or by reading too many notes, are counted separately.
+func (v *Views) RecordView(noteID int64, visit Visit) {
+	if v.ipHeaderName == "" || visit.IP == "" {
 		return
 	}
 
-	ipNum, ok := ipToUint32(ipHeader)
+	ip, ok := v.normalizeIP(visit.IP)
 	if !ok {
-		return // Ignore IPv6 or invalid IP
+		return // Ignore invalid IP
 	}
 
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
-	// Initialize IP set for this note if needed
-	if v.seenIPs[noteID] == nil {
-		v.seenIPs[noteID] = make(map[uint32]struct{})
+	now := time.Now()
+	v.rotateSalt(now)
+	key := v.visitorHash(noteID, ip, visit.UserAgent)
+
+	// Check if this visitor already viewed this note today
+	if _, seen := v.seen[key]; seen {
+		return
 	}
 
-	// Check if this IP already viewed this note
-	if _, seen := v.seenIPs[noteID][ipNum]; seen {
+	// A full set keeps deduplicating the visitors it holds until the salt rotates.
+	// New visitors can't be remembered, so counting them would let anyone inflate views.
+	if len(v.seen) >= maxSeen {
 		return
 	}
 
 	// Record the view
-	v.seenIPs[noteID][ipNum] = struct{}{}
+	v.seen[key] = struct{}{}
+	day := now.UTC().Format(time.DateOnly)
+	// Note ID 0 is never used, so this hashes the visitor across all notes
+	if v.seenNote(v.visitorHash(0, ip, visit.UserAgent), now) || visit.Bot {
+		v.bots[noteID]++
+		v.pending.Add(noteID, day, 1, true)
+		return
+	}
 	v.counts[noteID]++
+	v.pending.Add(noteID, day, 1, false)
+	if visit.Referrer != "" || visit.Source != "" || visit.Medium != "" || visit.Campaign != "" {
+		v.pending.Sources[models.ViewSource{
+			NoteID:   noteID,
+			Day:      day,
+			Referrer: visit.Referrer,
+			Source:   visit.Source,
+			Medium:   visit.Medium,
+			Campaign: visit.Campaign,
+		}]++
+	}
 }
 
 // GetViews returns the view count for a note
@@ -0,0 +1,101 @@
+package views
+
+import (
+	"path/filepath"
+	"testing"
+
+	"lava-notes/internal/db"
+)
+
+func newTestViews(t *testing.T) *Views {
+	t.Helper()
+	t.Setenv("IP_HEADER", "X-Real-IP")
+	database, err := db.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return New(database)
+}
+
+func TestSeenCapKeepsTheDay(t *testing.T) {
+	v := newTestViews(t)
+	alice := Visit{IP: "203.0.113.1", UserAgent: "Mozilla/5.0"}
+	bob := Visit{IP: "203.0.113.2", UserAgent: "Mozilla/5.0"}
+
+	v.RecordView(1, alice)
+	if got := v.GetViews(1); got != 1 {
+		t.Fatalf("first view: got %d views, want 1", got)
+	}
+	salt, day := v.salt, v.saltDay
+
+	// Fill the set up to the cap with other visitors
+	v.mu.Lock()
+	for i := uint64(0); len(v.seen) < maxSeen; i++ {
+		v.seen[i] = struct{}{}
+	}
+	v.mu.Unlock()
+
+	// Known visitors are still deduplicated, new ones are not counted
+	v.RecordView(1, alice)
+	v.RecordView(1, bob)
+	v.RecordView(1, bob)
+	if got := v.GetViews(1); got != 1 {
+		t.Fatalf("views at the cap: got %d, want 1", got)
+	}
+	if string(v.salt) != string(salt) || v.saltDay != day {
+		t.Fatal("salt rotated when the cap was reached")
+	}
+	if len(v.seen) != maxSeen {
+		t.Fatalf("seen grew to %d past the cap of %d", len(v.seen), maxSeen)
+	}
+
+	// The next day starts with an empty set
+	v.mu.Lock()
+	v.saltDay = "2000-01-01"
+	v.mu.Unlock()
+	v.RecordView(1, bob)
+	if got := v.GetViews(1); got != 2 {
+		t.Fatalf("views after rotation: got %d, want 2", got)
+	}
+	if len(v.seen) != 1 {
+		t.Fatalf("seen after rotation: got %d entries, want 1", len(v.seen))
+	}
+}
+
+func TestRangeLimits(t *testing.T) {
+	ranges := []struct {
+		from, to string
+		want     error
+	}{
+		{"2025-01-01", "2025-12-31", nil},
+		{"2024-01-01", "2024-12-31", nil}, // 366 days of a leap year
+		{"2025-01-01", "2026-01-01", nil},
+		{"2025-01-01", "2026-01-02", ErrRangeTooLong},
+		{"0001-01-01", "9999-12-31", ErrRangeTooLong},
+		{"2025-02-01", "2025-01-01", ErrInvalidRange},
+		{"2025-01-01", "tomorrow", ErrInvalidRange},
+	}
+	for _, r := range ranges {
+		if _, _, err := ParseRange(r.from, r.to); err != r.want {
+			t.Errorf("ParseRange(%s, %s): got %v, want %v", r.from, r.to, err, r.want)
+		}
+	}
+
+	periods := []struct {
+		period string
+		want   error
+	}{
+		{"366d", nil},
+		{"8784h", nil},
+		{"367d", ErrRangeTooLong},
+		{"8785h", ErrRangeTooLong},
+		{"99999999d", ErrRangeTooLong},
+		{"0d", ErrInvalidRange},
+	}
+	for _, p := range periods {
+		if _, _, err := ParsePeriod(p.period); err != p.want {
+			t.Errorf("ParsePeriod(%s): got %v, want %v", p.period, err, p.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package views
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxSourceLen bounds referrer hosts and campaign values kept per view
+const maxSourceLen = 64
+
+// Visit describes a single page view. Only the IP and user agent identify the
+// visitor and they are never stored, the rest is aggregated per note and day.
+type Visit struct {
+	IP        string
+	UserAgent string
+	Referrer  string // normalized host, "" for direct or internal traffic
+	Source    string // utm_source
+	Medium    string // utm_medium
+	Campaign  string // utm_campaign
+	Bot       bool   // known crawler or client that isn't a browser
+}
+
+// VisitFromRequest extracts a visit from a request. The SPA forwards
+// document.referrer as ?ref= and the landing page's utm_* parameters,
+// server-rendered pages use the Referer header and their own query.
+func (v *Views) VisitFromRequest(r *http.Request) Visit {
+	q := r.URL.Query()
+	ref := q.Get("ref")
+	if ref == "" {
+		ref = r.Header.Get("Referer")
+	}
+
+	visit := Visit{
+		UserAgent: r.UserAgent(),
+		Referrer:  referrerHost(ref, r.Host),
+		Source:    cleanParam(q.Get("utm_source")),
+		Medium:    cleanParam(q.Get("utm_medium")),
+		Campaign:  cleanParam(q.Get("utm_campaign")),
+	}
+	if v.ipHeaderName != "" {
+		visit.IP = r.Header.Get(v.ipHeaderName)
+	}
+	// Browsers always send Accept-Language, most scripted clients don't
+	visit.Bot = v.isBotAgent(visit.UserAgent) || r.Header.Get("Accept-Language") == ""
+	return visit
+}
+
+// referrerHost reduces a referrer URL to its host, dropping paths and queries
+// that could identify the visitor. Links from this site count as direct.
+func referrerHost(ref, ownHost string) string {
+	u, err := url.Parse(ref)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	host := strings.ToLower(u.Hostname())
+	host = strings.TrimPrefix(host, "www.")
+	own := ownHost
+	if h, _, err := net.SplitHostPort(ownHost); err == nil {
+		own = h
+	}
+	if host == strings.TrimPrefix(strings.ToLower(own), "www.") {
+		return ""
+	}
+	return truncate(host)
+}
+
+func cleanParam(s string) string {
+	return truncate(strings.ToLower(strings.TrimSpace(s)))
+}
+
+func truncate(s string) string {
+	if len(s) > maxSourceLen {
+		return s[:maxSourceLen]
+	}
+	return s
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	// ErrSecondFactorRequired is returned by Login when TOTP is enabled and no code was given
+	ErrSecondFactorRequired = errors.New("lava-notes: a TOTP or recovery code is required")
+	// ErrInvalidCode is returned by Login for a wrong TOTP or recovery code
+	ErrInvalidCode = errors.New("lava-notes: invalid TOTP or recovery code")
+)
+
+const sessionCookie = "lava_token"
+
+// Login exchanges a login link, or just its token, for a session. The code is
+// only needed when TOTP is enabled. Links are single use unless created with --uses,
+// keep the session with Token and WithToken instead of logging in again.
+func (c *Client) Login(ctx context.Context, link, code string) error {
+	token := link
+	if u, err := url.Parse(link); err == nil && u.Query().Has("token") {
+		token = u.Query().Get("token")
+	}
+
+	var req *http.Request
+	var err error
+	if code == "" {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/auth/login?"+url.Values{"token": {token}}.Encode(), nil)
+	} else {
+		form := url.Values{"token": {token}, "code": {code}}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/auth/login", strings.NewReader(form.Encode()))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// The session cookie is set on the redirect, which must not be followed
+	hc := *c.http
+	hc.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The second factor form is HTML, errors are JSON
+	html := strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html")
+	switch {
+	case resp.StatusCode == http.StatusOK && html:
+		return ErrSecondFactorRequired
+	case resp.StatusCode == http.StatusUnauthorized && html:
+		return ErrInvalidCode
+	case resp.StatusCode >= 300 && resp.StatusCode != http.StatusFound:
+		return decodeError(resp)
+	}
+
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == sessionCookie && cookie.Value != "" {
+			c.token = cookie.Value
+			return nil
+		}
+	}
+	return errors.New("lava-notes: login response has no session")
+}
+
+// CheckAuth reports whether the client is logged in as the writer
+func (c *Client) CheckAuth(ctx context.Context) (bool, error) {
+	var status struct {
+		Authenticated bool `json:"authenticated"`
+	}
+	_, err := c.do(ctx, http.MethodGet, "/api/auth/check", nil, nil, &status)
+	return status.Authenticated, err
+}
+
+// Logout forgets the session token. Tokens stay valid until they expire, the server keeps no sessions.
+func (c *Client) Logout(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodPost, "/api/auth/logout", nil, nil, nil)
+	c.token = ""
+	return err
+}
@@ -0,0 +1,215 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type Category struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Icon      string    `json:"icon"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type CategoryInput struct {
+	Name string `json:"name"`
+	Icon string `json:"icon,omitempty"`
+}
+
+type Note struct {
+	ID         int64     `json:"id"`
+	CategoryID int64     `json:"category_id"`
+	Name       string    `json:"name"`
+	Content    string    `json:"content"`
+	Icon       string    `json:"icon"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Views      int64     `json:"views,omitempty"` // only for the writer
+}
+
+// NoteInput creates a note. When updating, a zero CategoryID keeps the note in its category
+// and name, content and icon are all replaced.
+type NoteInput struct {
+	CategoryID int64  `json:"category_id,omitempty"`
+	Name       string `json:"name"`
+	Content    string `json:"content"`
+	Icon       string `json:"icon,omitempty"`
+}
+
+type NoteListItem struct {
+	ID         int64     `json:"id"`
+	CategoryID int64     `json:"category_id"`
+	Name       string    `json:"name"`
+	Icon       string    `json:"icon"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type SearchResult struct {
+	ID           int64  `json:"id"`
+	CategoryID   int64  `json:"category_id"`
+	CategoryName string `json:"category_name"`
+	Name         string `json:"name"`
+	Icon         string `json:"icon"`
+	Snippet      string `json:"snippet"`
+}
+
+// ListNotesOptions select notes, zero values don't filter
+type ListNotesOptions struct {
+	CategoryID   int64
+	Limit        int    // page size, at most 200
+	Cursor       string // NextCursor of the previous page
+	Sort         string // name, created, updated or views
+	Order        string // asc or desc
+	Icon         string
+	Lang         string // two-letter name suffix, en also matches notes without one
+	UpdatedSince time.Time
+}
+
+func (o ListNotesOptions) query() url.Values {
+	q := url.Values{}
+	if o.CategoryID != 0 {
+		q.Set("category_id", strconv.FormatInt(o.CategoryID, 10))
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	for key, value := range map[string]string{"cursor": o.Cursor, "sort": o.Sort, "order": o.Order, "icon": o.Icon, "lang": o.Lang} {
+		if value != "" {
+			q.Set(key, value)
+		}
+	}
+	if !o.UpdatedSince.IsZero() {
+		q.Set("updated_since", formatTime(o.UpdatedSince))
+	}
+	return q
+}
+
+// NotePage is one page of a listing, NextCursor is empty on the last page
+type NotePage struct {
+	Notes      []NoteListItem
+	NextCursor string
+}
+
+// Categories
+
+func (c *Client) ListCategories(ctx context.Context) ([]Category, error) {
+	var categories []Category
+	_, err := c.do(ctx, http.MethodGet, "/api/categories", nil, nil, &categories)
+	return categories, err
+}
+
+func (c *Client) GetCategory(ctx context.Context, id int64) (*Category, error) {
+	var category Category
+	if _, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/categories/%d", id), nil, nil, &category); err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+func (c *Client) CreateCategory(ctx context.Context, in CategoryInput) (*Category, error) {
+	var category Category
+	if _, err := c.do(ctx, http.MethodPost, "/api/categories", nil, in, &category); err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+func (c *Client) UpdateCategory(ctx context.Context, id int64, in CategoryInput) (*Category, error) {
+	var category Category
+	if _, err := c.do(ctx, http.MethodPut, fmt.Sprintf("/api/categories/%d", id), nil, in, &category); err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+// DeleteCategory deletes a category with all of its notes
+func (c *Client) DeleteCategory(ctx context.Context, id int64) error {
+	_, err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/api/categories/%d", id), nil, nil, nil)
+	return err
+}
+
+// Notes
+
+// ListNotes returns a page of notes, or every note of CategoryID when no other option is set.
+// Without CategoryID, one of the other options must be set.
+func (c *Client) ListNotes(ctx context.Context, opts ListNotesOptions) (*NotePage, error) {
+	return c.listNotes(ctx, "/api/notes", opts.query())
+}
+
+// ListRecentNotes returns recently updated notes across categories, Sort and Order are ignored
+func (c *Client) ListRecentNotes(ctx context.Context, opts ListNotesOptions) (*NotePage, error) {
+	return c.listNotes(ctx, "/api/notes/recent", opts.query())
+}
+
+// AllNotes follows the cursors of ListNotes until the last page
+func (c *Client) AllNotes(ctx context.Context, opts ListNotesOptions) ([]NoteListItem, error) {
+	if opts.Limit == 0 {
+		opts.Limit = 200
+	}
+	var all []NoteListItem
+	for {
+		page, err := c.ListNotes(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page.Notes...)
+		if page.NextCursor == "" {
+			return all, nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+func (c *Client) listNotes(ctx context.Context, path string, q url.Values) (*NotePage, error) {
+	page := &NotePage{}
+	resp, err := c.do(ctx, http.MethodGet, path, q, nil, &page.Notes)
+	if err != nil {
+		return nil, err
+	}
+	page.NextCursor = resp.Header.Get("X-Next-Cursor")
+	return page, nil
+}
+
+// GetNote returns a note, counting a view unless the client is logged in
+func (c *Client) GetNote(ctx context.Context, id int64) (*Note, error) {
+	var note Note
+	if _, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/notes/%d", id), nil, nil, &note); err != nil {
+		return nil, err
+	}
+	return &note, nil
+}
+
+func (c *Client) CreateNote(ctx context.Context, in NoteInput) (*Note, error) {
+	var note Note
+	if _, err := c.do(ctx, http.MethodPost, "/api/notes", nil, in, &note); err != nil {
+		return nil, err
+	}
+	return &note, nil
+}
+
+func (c *Client) UpdateNote(ctx context.Context, id int64, in NoteInput) (*Note, error) {
+	var note Note
+	if _, err := c.do(ctx, http.MethodPut, fmt.Sprintf("/api/notes/%d", id), nil, in, &note); err != nil {
+		return nil, err
+	}
+	return &note, nil
+}
+
+func (c *Client) DeleteNote(ctx context.Context, id int64) error {
+	_, err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/api/notes/%d", id), nil, nil, nil)
+	return err
+}
+
+// SearchNotes searches note names and contents, query must be at least 3 characters
+func (c *Client) SearchNotes(ctx context.Context, query string) ([]SearchResult, error) {
+	var results []SearchResult
+	_, err := c.do(ctx, http.MethodGet, "/api/notes/search", url.Values{"q": {query}}, nil, &results)
+	return results, err
+}
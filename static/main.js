@@ -572,7 +572,14 @@ const app = createApp({
       if (match) {
         const noteId = parseInt(match[1], 10);
         try {
-          const res = await fetch(api(`notes/${noteId}`));
+          // Forward where the visitor came from, the API request itself has no useful Referer
+          const track = new URLSearchParams();
+          if (document.referrer) track.set("ref", document.referrer);
+          for (const [key, value] of new URLSearchParams(window.location.search)) {
+            if (key.startsWith("utm_")) track.set(key, value);
+          }
+          const qs = track.toString();
+          const res = await fetch(api(`notes/${noteId}${qs ? `?${qs}` : ""}`));
           if (res.ok) {
             const note = await res.json();
             await decryptNote(note);